@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"wol/logger"
+	"wol/storage"
+)
+
+// actorHeader names who is making a request, e.g. "cli (alice@laptop)". It
+// is recorded in the audit log as given; without it the actor is "api".
+const actorHeader = "X-WOL-Actor"
+
+// actorOf returns who made r and from where, for the audit log.
+func actorOf(r *http.Request) storage.Actor {
+	name := strings.TrimSpace(r.Header.Get(actorHeader))
+	if name == "" {
+		name = "api"
+	}
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return storage.Actor{Name: name, IP: ip}
+}
+
+// describeActor renders an actor for log messages, e.g. "web from
+// 192.168.1.20".
+func describeActor(a storage.Actor) string {
+	if a.IP == "" {
+		return a.Name
+	}
+	return a.Name + " from " + a.IP
+}
+
+// cliActor is the actor of changes made on the command line: the local user
+// and host.
+func cliActor() storage.Actor {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, _ := os.Hostname()
+	return storage.Actor{Name: fmt.Sprintf("cli (%s@%s)", name, host)}
+}
+
+// leaseSyncActor is the actor of the periodic lease_sync refresh.
+var leaseSyncActor = storage.Actor{Name: "lease sync"}
+
+// auditErrorStatus is the HTTP status for an error reverting or restoring.
+func auditErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrEventNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrAuditExpired):
+		return http.StatusBadRequest
+	}
+	return http.StatusConflict
+}
+
+// handleAudit lists recorded changes, newest first. ?device= (ID or name) or
+// ?key= (network name) limits them to one object, ?limit= to a number.
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	key := query.Get("device")
+	if key == "" {
+		key = query.Get("key")
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	json.NewEncoder(w).Encode(store.AuditLog(key, limit))
+}
+
+// handleAuditAction serves GET /api/audit/{id}, POST /api/audit/{id}/revert
+// and POST /api/audit/restore, which takes ?id= (the state right after that
+// change, 0 for before every recorded change) or ?time= (RFC 3339).
+func handleAuditAction(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/audit/")
+	if path == "restore" {
+		handleRestore(w, r)
+		return
+	}
+
+	idStr, action, _ := strings.Cut(path, "/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		e, found := store.AuditEvent(id)
+		if !found {
+			http.Error(w, storage.ErrEventNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(e)
+	case action == "revert" && r.Method == http.MethodPost:
+		e, err := store.Revert(actorOf(r), id)
+		if err != nil {
+			http.Error(w, err.Error(), auditErrorStatus(err))
+			return
+		}
+		logger.Info("System", fmt.Sprintf("Reverted change #%d (%s)", id, describeEvent(e)))
+		json.NewEncoder(w).Encode(e)
+	case action == "" || action == "revert":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func handleRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	var id int64
+	switch {
+	case query.Get("id") != "":
+		var err error
+		if id, err = strconv.ParseInt(query.Get("id"), 10, 64); err != nil {
+			http.Error(w, "Invalid event ID", http.StatusBadRequest)
+			return
+		}
+	case query.Get("time") != "":
+		t, err := time.Parse(time.RFC3339, query.Get("time"))
+		if err != nil {
+			http.Error(w, "Invalid time, use RFC 3339 such as 2024-05-01T12:00:00Z", http.StatusBadRequest)
+			return
+		}
+		if id, err = store.EventAt(t); err != nil {
+			http.Error(w, err.Error(), auditErrorStatus(err))
+			return
+		}
+	default:
+		http.Error(w, "id or time required", http.StatusBadRequest)
+		return
+	}
+
+	undone, err := store.Restore(actorOf(r), id)
+	if err != nil {
+		http.Error(w, err.Error(), auditErrorStatus(err))
+		return
+	}
+	logger.Info("System", fmt.Sprintf("Config restored to change #%d, %d changes undone", id, undone))
+	json.NewEncoder(w).Encode(map[string]int64{"id": id, "undone": int64(undone)})
+}
+
+// describeEvent summarizes a change for the log, e.g. `device "NAS" update`.
+func describeEvent(e storage.AuditEvent) string {
+	if e.Name != "" {
+		return fmt.Sprintf("%s %q %s", e.Kind, e.Name, e.Action)
+	}
+	return e.Kind + " " + e.Action
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const unitName = "wol.service"
+
+// unitPath returns where the systemd unit is installed, either system-wide
+// or as a user unit depending on the -user flag.
+func unitPath() (string, error) {
+	if !*userUnit {
+		return filepath.Join("/etc/systemd/system", unitName), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user", unitName), nil
+}
+
+func systemctl(args ...string) error {
+	if *userUnit {
+		args = append([]string{"--user"}, args...)
+	}
+	cmd := exec.Command("systemctl", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func unitContent() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	// wol.json, wol.pid and the default log dir are relative to the
+	// working directory, so pin it to where the unit was installed from.
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	dir, err := unitValue(wd)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=WOL Manager\n")
+	if !*userUnit {
+		b.WriteString("Wants=network-online.target\n")
+		b.WriteString("After=network-online.target\n")
+	}
+	b.WriteString("\n[Service]\n")
+	b.WriteString("Type=notify\n")
+	if *configFile != "wol.json" {
+		fmt.Fprintf(&b, "ExecStart=%s -config %s\n", quoteExecArg(exe), quoteExecArg(*configFile))
+	} else {
+		fmt.Fprintf(&b, "ExecStart=%s\n", quoteExecArg(exe))
+	}
+	b.WriteString("ExecReload=/bin/kill -HUP $MAINPID\n")
+	fmt.Fprintf(&b, "WorkingDirectory=%s\n", dir)
+	b.WriteString("Restart=on-failure\n")
+	b.WriteString("WatchdogSec=30\n")
+	b.WriteString("\n[Install]\n")
+	if *userUnit {
+		b.WriteString("WantedBy=default.target\n")
+	} else {
+		b.WriteString("WantedBy=multi-user.target\n")
+	}
+	return b.String(), nil
+}
+
+// quoteExecArg quotes s as a single argument of an Exec*= line, see
+// systemd.syntax(7). Specifiers and variables are escaped too, since systemd
+// expands them even inside quotes.
+func quoteExecArg(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '%':
+			b.WriteString("%%")
+		case c == '$':
+			b.WriteString("$$")
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			// Anything else, including UTF-8, is taken literally
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// unitValue escapes s for a setting that takes the rest of the line as it
+// is, such as WorkingDirectory=. Spaces need no quoting there, and quotes
+// would be kept, but specifiers must be escaped and surrounding whitespace
+// or line breaks can't be represented.
+func unitValue(s string) (string, error) {
+	if strings.ContainsAny(s, "\r\n") || strings.TrimSpace(s) != s || strings.HasSuffix(s, "\\") {
+		return "", fmt.Errorf("%q can't be used in a systemd unit", s)
+	}
+	return strings.ReplaceAll(s, "%", "%%"), nil
+}
+
+// setAutoStart installs and enables the systemd unit, or disables and
+// removes it.
+func setAutoStart(enable bool) error {
+	path, err := unitPath()
+	if err != nil {
+		return err
+	}
+
+	if !enable {
+		if err := systemctl("disable", "--now", unitName); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return systemctl("daemon-reload")
+	}
+
+	content, err := unitContent()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return err
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl("enable", "--now", unitName)
+}
+
+func isAutoStartEnabled() bool {
+	args := []string{"is-enabled", "--quiet", unitName}
+	if *userUnit {
+		args = append([]string{"--user"}, args...)
+	}
+	return exec.Command("systemctl", args...).Run() == nil
+}
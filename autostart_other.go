@@ -1,9 +1,11 @@
-//go:build !windows
+//go:build !windows && !linux
 
 package main
 
+import "errors"
+
 func setAutoStart(enable bool) error {
-	return nil // Not implemented for non-windows
+	return errors.New("autostart is not supported on this platform")
 }
 
 func isAutoStartEnabled() bool {
@@ -0,0 +1,1781 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"wol/client"
+	"wol/devicefile"
+	"wol/logger"
+	"wol/oui"
+	"wol/storage"
+)
+
+// backend is what the CLI subcommands operate on: either wol.json directly or
+// a running server through its HTTP API.
+// Devices are addressed by ID, though names are accepted too.
+type backend interface {
+	Devices() ([]storage.Device, error)
+	AddDevice(d storage.Device) (storage.Device, error)
+	UpdateDevice(key string, d storage.Device) (storage.Device, error)
+	DeleteDevice(key string) error
+	SetMaintenance(key string, m storage.Maintenance) (storage.Device, error)
+	ClearMaintenance(key string) (storage.Device, error)
+	SetProtection(key, level, pin string) (storage.Device, error)
+	ClearProtection(key string) (storage.Device, error)
+	Trash() ([]storage.TrashedDevice, error)
+	RestoreDevice(key string) (storage.Device, error)
+	PurgeDevice(key string) error
+	EmptyTrash() error
+	ReorderDevices(keys []string) error
+	Wake(key string, proof storage.WakeProof) (string, error)
+	WakeMAC(req client.WakeMACRequest) (string, error)
+	Ping(key string) (client.Status, error)
+	Logs(device string, limit int) ([]logger.LogEntry, error)
+	Import(format string, data []byte, dryRun bool) (storage.ImportPlan, error)
+	Export(format string) ([]byte, error)
+	SyncIPs(format string, data []byte, dryRun bool) ([]storage.IPChange, error)
+	Networks() ([]storage.Network, error)
+	AddNetwork(n storage.Network) (storage.Network, error)
+	UpdateNetwork(name string, n storage.Network) (storage.Network, error)
+	DeleteNetwork(name string) error
+	History(key string, limit int) ([]storage.AuditEvent, error)
+	Revert(id int64) (storage.AuditEvent, error)
+	Restore(id int64) (int, error)
+	RestoreTime(t time.Time) (int, error)
+}
+
+// localBackend works on the config file in-process, logging the same way the
+// server's handlers do.
+type localBackend struct {
+	store *storage.Store
+}
+
+func (b *localBackend) Devices() ([]storage.Device, error) {
+	return b.store.GetAll(), nil
+}
+
+func (b *localBackend) AddDevice(d storage.Device) (storage.Device, error) {
+	d, err := b.store.AddDevice(cliActor(), d)
+	if err != nil {
+		return d, err
+	}
+	logger.DeviceInfo(d.ID, d.Name, "Device added")
+	return d, nil
+}
+
+func (b *localBackend) UpdateDevice(key string, d storage.Device) (storage.Device, error) {
+	old, found := b.store.GetDevice(key)
+	if !found {
+		return d, errors.New("device not found")
+	}
+	d, err := b.store.UpdateDevice(cliActor(), key, d)
+	if err != nil {
+		return d, err
+	}
+	if old.Name != d.Name {
+		logger.DeviceInfo(d.ID, d.Name, fmt.Sprintf("Device updated (old name: %s)", old.Name))
+	} else {
+		logger.DeviceInfo(d.ID, d.Name, "Device updated")
+	}
+	return d, nil
+}
+
+func (b *localBackend) DeleteDevice(key string) error {
+	d, err := b.store.DeleteDevice(cliActor(), key)
+	if err != nil {
+		return err
+	}
+	logDelete(d, false)
+	return nil
+}
+
+func (b *localBackend) SetMaintenance(key string, m storage.Maintenance) (storage.Device, error) {
+	d, err := b.store.SetMaintenance(cliActor(), key, &m)
+	if err != nil {
+		return d, err
+	}
+	logMaintenance(d)
+	return d, nil
+}
+
+func (b *localBackend) ClearMaintenance(key string) (storage.Device, error) {
+	d, err := b.store.SetMaintenance(cliActor(), key, nil)
+	if err != nil {
+		return d, err
+	}
+	logMaintenance(d)
+	return d, nil
+}
+
+func (b *localBackend) SetProtection(key, level, pin string) (storage.Device, error) {
+	d, err := b.store.SetProtection(cliActor(), key, level, pin)
+	if err != nil {
+		return d, err
+	}
+	logProtection(d)
+	return d, nil
+}
+
+func (b *localBackend) ClearProtection(key string) (storage.Device, error) {
+	d, err := b.store.SetProtection(cliActor(), key, "", "")
+	if err != nil {
+		return d, err
+	}
+	logProtection(d)
+	return d, nil
+}
+
+func (b *localBackend) Trash() ([]storage.TrashedDevice, error) {
+	return b.store.GetTrash(), nil
+}
+
+func (b *localBackend) RestoreDevice(key string) (storage.Device, error) {
+	d, err := b.store.RestoreDevice(cliActor(), key)
+	if err != nil {
+		return d, err
+	}
+	logger.DeviceInfo(d.ID, d.Name, "Device restored from trash")
+	return d, nil
+}
+
+func (b *localBackend) PurgeDevice(key string) error {
+	d, err := b.store.PurgeDevice(cliActor(), key)
+	if err != nil {
+		return err
+	}
+	logger.DeviceInfo(d.ID, d.Name, "Device purged from trash")
+	return nil
+}
+
+func (b *localBackend) EmptyTrash() error {
+	devices, err := b.store.EmptyTrash(cliActor())
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		logger.DeviceInfo(d.ID, d.Name, "Device purged from trash")
+	}
+	return nil
+}
+
+func (b *localBackend) ReorderDevices(keys []string) error {
+	return b.store.ReorderDevices(cliActor(), keys)
+}
+
+func (b *localBackend) Wake(key string, proof storage.WakeProof) (string, error) {
+	device, found := b.store.GetDevice(key)
+	if !found {
+		return "", errors.New("device not found")
+	}
+	if err := authorizeWake(device, proof, describeActor(cliActor())); err != nil {
+		return "", err
+	}
+	msg, _, err := wakeDevice(context.Background(), device, b.store.Expand(device))
+	return msg, err
+}
+
+func (b *localBackend) WakeMAC(req client.WakeMACRequest) (string, error) {
+	mac, opts, err := parseWakeMAC(req)
+	if err != nil {
+		return "", err
+	}
+	return wakeMAC(context.Background(), b.store, mac, opts, describeActor(cliActor()))
+}
+
+func (b *localBackend) Ping(key string) (client.Status, error) {
+	device, found := b.store.GetDevice(key)
+	if !found {
+		return client.Status{}, errors.New("device not found")
+	}
+	return checkDevice(device, b.store.Expand(device))
+}
+
+func (b *localBackend) Logs(device string, limit int) ([]logger.LogEntry, error) {
+	return logger.GetLogs(device, limit)
+}
+
+func (b *localBackend) Import(format string, data []byte, dryRun bool) (storage.ImportPlan, error) {
+	devices, err := devicefile.Read(format, bytes.NewReader(data))
+	if err != nil {
+		return storage.ImportPlan{}, err
+	}
+	plan, err := b.store.Import(cliActor(), devices, dryRun)
+	if err != nil {
+		return plan, err
+	}
+	logImport(plan, format)
+	return plan, nil
+}
+
+func (b *localBackend) SyncIPs(format string, data []byte, dryRun bool) ([]storage.IPChange, error) {
+	leases, err := devicefile.Read(format, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	changes, err := b.store.RefreshIPs(cliActor(), leases, dryRun)
+	if err != nil {
+		return changes, err
+	}
+	if !dryRun {
+		logIPChanges(changes, "command line")
+	}
+	return changes, nil
+}
+
+func (b *localBackend) Export(format string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := devicefile.Write(format, &buf, exportDevices(b.store.GetAll()))
+	return buf.Bytes(), err
+}
+
+func (b *localBackend) Networks() ([]storage.Network, error) {
+	return b.store.GetNetworks(), nil
+}
+
+func (b *localBackend) AddNetwork(n storage.Network) (storage.Network, error) {
+	n, err := b.store.AddNetwork(cliActor(), n)
+	if err != nil {
+		return n, err
+	}
+	logger.Info("System", fmt.Sprintf("Network %s added", n.Name))
+	return n, nil
+}
+
+func (b *localBackend) UpdateNetwork(name string, n storage.Network) (storage.Network, error) {
+	n, err := b.store.UpdateNetwork(cliActor(), name, n)
+	if err != nil {
+		return n, err
+	}
+	if n.Name != name {
+		logger.Info("System", fmt.Sprintf("Network %s updated (old name: %s)", n.Name, name))
+	} else {
+		logger.Info("System", fmt.Sprintf("Network %s updated", n.Name))
+	}
+	return n, nil
+}
+
+func (b *localBackend) DeleteNetwork(name string) error {
+	n, err := b.store.DeleteNetwork(cliActor(), name)
+	if err != nil {
+		return err
+	}
+	logger.Info("System", fmt.Sprintf("Network %s deleted", n.Name))
+	return nil
+}
+
+func (b *localBackend) History(key string, limit int) ([]storage.AuditEvent, error) {
+	return b.store.AuditLog(key, limit), nil
+}
+
+func (b *localBackend) Revert(id int64) (storage.AuditEvent, error) {
+	e, err := b.store.Revert(cliActor(), id)
+	if err != nil {
+		return e, err
+	}
+	logger.Info("System", fmt.Sprintf("Reverted change #%d (%s)", id, describeEvent(e)))
+	return e, nil
+}
+
+func (b *localBackend) Restore(id int64) (int, error) {
+	undone, err := b.store.Restore(cliActor(), id)
+	if err != nil {
+		return undone, err
+	}
+	logger.Info("System", fmt.Sprintf("Config restored to change #%d, %d changes undone", id, undone))
+	return undone, nil
+}
+
+func (b *localBackend) RestoreTime(t time.Time) (int, error) {
+	id, err := b.store.EventAt(t)
+	if err != nil {
+		return 0, err
+	}
+	return b.Restore(id)
+}
+
+// commandNames lists the subcommands accepted as the first argument.
+var commandNames = []string{"list", "add", "edit", "rm", "reorder", "wake", "status", "logs", "import", "export", "sync", "network", "wake-mac", "maintenance", "protect", "trash", "history", "revert", "restore", "remote"}
+
+func isCommand(name string) bool {
+	for _, c := range commandNames {
+		if c == name {
+			return true
+		}
+	}
+	return name == "help"
+}
+
+// cmdFlags holds the options shared by every subcommand.
+type cmdFlags struct {
+	*flag.FlagSet
+	json   *bool
+	server *string
+	token  *string
+	local  *bool
+	config *string
+}
+
+func newCmdFlags(name, usage string) *cmdFlags {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	f := &cmdFlags{
+		FlagSet: fs,
+		json:    fs.Bool("json", false, "Print machine-readable JSON"),
+		server:  fs.String("server", "", "URL of a running server, e.g. http://localhost:8888 (default: the server set with \"wol remote set\", else the config file)"),
+		token:   fs.String("token", "", "API token for --server"),
+		local:   fs.Bool("local", false, "Edit the config file directly even if a remote server is set"),
+		config:  fs.String("config", "wol.json", "Config file to use when not talking to a server; a .db file selects the embedded database"),
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: wol %s %s\n\nOptions:\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return f
+}
+
+// parse accepts flags before, after and between positional arguments.
+func (f *cmdFlags) parse(args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := f.Parse(args); err != nil {
+			return nil, err
+		}
+		args = f.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// backend opens the server named by --server or the client config file,
+// falling back to the local config file. mustExist guards read-only commands
+// against silently creating a new config in the wrong directory.
+func (f *cmdFlags) backend(mustExist bool) (backend, error) {
+	if *f.server != "" {
+		c := client.New(*f.server)
+		c.Token = *f.token
+		c.Actor = cliActor().Name
+		return c, nil
+	}
+	if !*f.local {
+		path, err := client.DefaultConfigPath()
+		if err != nil {
+			return nil, err
+		}
+		cfg, err := client.LoadConfig(path)
+		if err == nil {
+			if *f.token != "" {
+				cfg.Token = *f.token
+			}
+			c := cfg.Client()
+			c.Actor = cliActor().Name
+			return c, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to load %s: %v", path, err)
+		}
+	}
+
+	if mustExist {
+		if !storage.Exists(*f.config) {
+			return nil, fmt.Errorf("%s not found (use --config or --server)", *f.config)
+		}
+	}
+	s, err := storage.NewStore(*f.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %v", *f.config, err)
+	}
+	if err := logger.Init(s.LogDir, s.LogRetentionDays); err != nil {
+		return nil, fmt.Errorf("failed to initialize logger: %v", err)
+	}
+	if backup := s.RecoveredFrom(); backup != "" {
+		msg := fmt.Sprintf("%s was corrupt, restored from backup %s", *f.config, backup)
+		fmt.Fprintln(os.Stderr, msg)
+		logger.Error("System", msg)
+	}
+	if from := s.ImportedFrom(); from != "" {
+		msg := fmt.Sprintf("Imported %s into %s, the old file was renamed to %s.imported", from, *f.config, from)
+		fmt.Fprintln(os.Stderr, msg)
+		logger.Info("System", msg)
+	}
+	return &localBackend{store: s}, nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// runCommand executes a subcommand and reports any error on stderr.
+func runCommand(name string, args []string) int {
+	var err error
+	switch name {
+	case "list":
+		err = cmdList(args)
+	case "add":
+		err = cmdAdd(args)
+	case "edit":
+		err = cmdEdit(args)
+	case "rm":
+		err = cmdRm(args)
+	case "reorder":
+		err = cmdReorder(args)
+	case "wake":
+		err = cmdWake(args)
+	case "status":
+		err = cmdStatus(args)
+	case "logs":
+		err = cmdLogs(args)
+	case "import":
+		err = cmdImport(args)
+	case "export":
+		err = cmdExport(args)
+	case "sync":
+		err = cmdSync(args)
+	case "network":
+		err = cmdNetwork(args)
+	case "wake-mac":
+		err = cmdWakeMAC(args)
+	case "maintenance":
+		err = cmdMaintenance(args)
+	case "protect":
+		err = cmdProtect(args)
+	case "trash":
+		err = cmdTrash(args)
+	case "history":
+		err = cmdHistory(args)
+	case "revert":
+		err = cmdRevert(args)
+	case "restore":
+		err = cmdRestore(args)
+	case "remote":
+		err = cmdRemote(args)
+	default:
+		printUsage(os.Stdout)
+		return 0
+	}
+
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, `Usage:
+  wol [options]             Run the server
+  wol <command> [options]   Manage devices
+
+Commands:
+  list                      List devices
+  add <name>                Add a device
+  edit <device>             Change a device
+  rm <device>...            Move devices to the trash (--purge to delete for good)
+  reorder <device>...       Set the display order of all devices
+  wake <device|mac>...      Send magic packets to devices (--tag/--folder for many,
+                            --confirm or --pin for protected devices)
+  wake-mac <mac>            Send magic packets to a machine that isn't saved
+  status [device...]        Show whether devices are online
+  logs                      Show recent log entries
+  import <file>             Add and update devices from a file (--dry-run to preview)
+  export                    Write all devices as JSON, CSV, ethers or dnsmasq lines
+  sync <file>               Refresh device IPs by MAC from DHCP leases or reservations
+  network list|add|edit|rm  Manage the networks members can be placed on
+  maintenance <device>      Block waking a device (--reason, --until or --for; --off to end)
+  protect <device>          Require --confirm or a PIN to wake a device (--off to remove)
+  trash list|restore|purge|empty
+                            Show, restore or delete for good the trashed devices
+  history [device]          Show recorded config changes, who made them and what changed
+  revert <change>           Undo one recorded change
+  restore <change|time>     Return the whole config to a recorded change or a point in time
+  remote set <url>          Make the commands above talk to a remote server
+  remote show|unset         Show or remove the remote server setting
+
+Devices are given by ID or name. Run "wol <command> -h" for command options.
+
+Server options:
+`)
+	flag.CommandLine.SetOutput(w)
+	flag.PrintDefaults()
+}
+
+func cmdList(args []string) error {
+	f := newCmdFlags("list", "[options]")
+	ff := addFilterFlags(f)
+	search := f.String("search", "", "Only devices with this text in the name, a tag, the folder or a member's MAC, IP or remark")
+	if _, err := f.parse(args); err != nil {
+		return err
+	}
+	b, err := f.backend(true)
+	if err != nil {
+		return err
+	}
+
+	all, err := b.Devices()
+	if err != nil {
+		return err
+	}
+	filter := ff.filter()
+	filter.Query = *search
+	devices := []storage.Device{}
+	for _, d := range all {
+		if filter.Match(d) {
+			devices = append(devices, d)
+		}
+	}
+	if *f.json {
+		return printJSON(devices)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tMAC\tHOST\tVENDOR\tMEMBERS\tFOLDER\tTAGS")
+	for _, d := range devices {
+		subs := deviceMembers(d)
+		if len(d.Members) > 0 {
+			subs = nil
+			for _, m := range storage.Expand(all, d) {
+				subs = append(subs, m.SubDevice)
+			}
+		}
+		var macs, hosts, vendors []string
+		for _, sub := range subs {
+			mac := sub.MAC
+			if oui.LocallyAdministered(mac) {
+				mac += " (randomized)"
+			}
+			macs = append(macs, mac)
+			if sub.IP != "" {
+				hosts = append(hosts, sub.IP)
+			}
+			if v := oui.Lookup(sub.MAC); v != "" && !slices.Contains(vendors, v) {
+				vendors = append(vendors, v)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", d.ID, d.Name, strings.Join(macs, ","), strings.Join(hosts, ","), strings.Join(vendors, ","), len(subs), d.Folder, strings.Join(d.Tags, ","))
+	}
+	return tw.Flush()
+}
+
+// deviceMembers returns the sub-devices of a group, or the legacy top-level
+// fields of a single device as its only member. Groups that only reference
+// other devices have none.
+func deviceMembers(d storage.Device) []storage.SubDevice {
+	if len(d.SubDevices) > 0 || (d.MAC == "" && len(d.Members) > 0) {
+		return d.SubDevices
+	}
+	return []storage.SubDevice{{
+		MAC:         d.MAC,
+		IP:          d.IP,
+		Port:        d.Port,
+		BroadcastIP: d.BroadcastIP,
+	}}
+}
+
+// subDeviceFlags are the per-member options of add and edit.
+type subDeviceFlags struct {
+	mac, ip, broadcast, remark, network *string
+	port                                *int
+}
+
+func addSubDeviceFlags(f *cmdFlags) subDeviceFlags {
+	return subDeviceFlags{
+		mac:       f.String("mac", "", "MAC address"),
+		ip:        f.String("ip", "", "IP or hostname used for the online check"),
+		port:      f.Int("port", 9, "UDP port for the magic packet (0 with --network: the network's)"),
+		broadcast: f.String("broadcast", "", "Broadcast IP (default: the network's, else all interfaces)"),
+		remark:    f.String("remark", "", "Remark"),
+		network:   f.String("network", "", "Network the member is on"),
+	}
+}
+
+// given reports whether any per-member option was set on the command line.
+func (sf subDeviceFlags) given(f *cmdFlags) bool {
+	given := false
+	f.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "mac", "ip", "port", "broadcast", "remark", "network":
+			given = true
+		}
+	})
+	return given
+}
+
+// apply copies the options given on the command line onto sub.
+func (sf subDeviceFlags) apply(f *cmdFlags, sub *storage.SubDevice) {
+	f.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "mac":
+			sub.MAC = *sf.mac
+		case "ip":
+			sub.IP = *sf.ip
+		case "port":
+			sub.Port = *sf.port
+		case "broadcast":
+			sub.BroadcastIP = *sf.broadcast
+		case "remark":
+			sub.Remark = *sf.remark
+		case "network":
+			sub.Network = *sf.network
+		}
+	})
+}
+
+// placementFlags are the options that file a device under tags and a
+// folder, and make it a group of other devices.
+type placementFlags struct {
+	tags    *string
+	folder  *string
+	members *string
+}
+
+func addPlacementFlags(f *cmdFlags) placementFlags {
+	return placementFlags{
+		tags:    f.String("tags", "", "Comma-separated tags, replacing the current ones"),
+		folder:  f.String("folder", "", `Folder or location, levels separated by "/"`),
+		members: f.String("members", "", "Comma-separated devices (IDs or names) the group includes, replacing the current ones"),
+	}
+}
+
+// apply copies the options given on the command line onto d.
+func (pf placementFlags) apply(f *cmdFlags, d *storage.Device) {
+	f.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "tags":
+			d.Tags = strings.Split(*pf.tags, ",")
+		case "folder":
+			d.Folder = *pf.folder
+		case "members":
+			d.Members = strings.Split(*pf.members, ",")
+		}
+	})
+}
+
+// filterFlags select devices by tag and folder.
+type filterFlags struct {
+	tag    *string
+	folder *string
+}
+
+func addFilterFlags(f *cmdFlags) filterFlags {
+	return filterFlags{
+		tag:    f.String("tag", "", "Only devices with these tags (comma-separated, all must match)"),
+		folder: f.String("folder", "", "Only devices in this folder or below it"),
+	}
+}
+
+func (ff filterFlags) filter() storage.Filter {
+	return storage.Filter{Tags: strings.Split(*ff.tag, ","), Folder: *ff.folder}.Clean()
+}
+
+// set reports whether the filter options select by anything, ignoring
+// blank tags and folders.
+func (ff filterFlags) set() bool {
+	filter := ff.filter()
+	return len(filter.Tags) > 0 || filter.Folder != ""
+}
+
+// readDeviceFile decodes a device from a JSON file, or stdin for "-".
+func readDeviceFile(path string) (storage.Device, error) {
+	var d storage.Device
+	r := os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return d, err
+		}
+		defer file.Close()
+		r = file
+	}
+	err := json.NewDecoder(r).Decode(&d)
+	return d, err
+}
+
+func cmdAdd(args []string) error {
+	f := newCmdFlags("add", "<name> --mac MAC|--members DEVICES [options]")
+	sf := addSubDeviceFlags(f)
+	pf := addPlacementFlags(f)
+	pingMode := f.String("ping-mode", "any", `Group online check: "any" or "all"`)
+	file := f.String("file", "", `Read the whole device as JSON from this file ("-" for stdin), e.g. for groups`)
+	args, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+
+	var d storage.Device
+	if *file != "" {
+		if d, err = readDeviceFile(*file); err != nil {
+			return err
+		}
+	} else {
+		if *sf.mac == "" && *pf.members == "" {
+			return errors.New("--mac or --members is required")
+		}
+		d.PingMode = *pingMode
+		if *sf.mac != "" {
+			sub := storage.SubDevice{Port: 9}
+			if *sf.network != "" {
+				sub.Port = 0 // Use the network's unless --port is given
+			}
+			sf.apply(f, &sub)
+			d.SubDevices = []storage.SubDevice{sub}
+		}
+	}
+	if len(args) > 0 {
+		d.Name = args[0]
+	}
+	if d.Name == "" {
+		return errors.New("name is required")
+	}
+	pf.apply(f, &d)
+
+	b, err := f.backend(false)
+	if err != nil {
+		return err
+	}
+	d, err = b.AddDevice(d)
+	if err != nil {
+		return err
+	}
+	if *f.json {
+		return printJSON(d)
+	}
+	fmt.Printf("Device %q added (ID %s).\n", d.Name, d.ID)
+	return nil
+}
+
+// findDevice looks a device up by ID or exact name.
+func findDevice(b backend, key string) (storage.Device, error) {
+	devices, err := b.Devices()
+	if err != nil {
+		return storage.Device{}, err
+	}
+	return findIn(devices, key)
+}
+
+func findIn(devices []storage.Device, key string) (storage.Device, error) {
+	for _, d := range devices {
+		if d.ID == key {
+			return d, nil
+		}
+	}
+	for _, d := range devices {
+		if d.Name == key {
+			return d, nil
+		}
+	}
+	return storage.Device{}, fmt.Errorf("device %q not found", key)
+}
+
+func cmdEdit(args []string) error {
+	f := newCmdFlags("edit", "<device> [options]")
+	sf := addSubDeviceFlags(f)
+	pf := addPlacementFlags(f)
+	newName := f.String("name", "", "Rename the device")
+	pingMode := f.String("ping-mode", "", `Group online check: "any" or "all"`)
+	index := f.Int("index", 1, "Which group member the MAC/IP/port options apply to (1-based)")
+	args, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		f.Usage()
+		return errors.New("exactly one device is required")
+	}
+
+	b, err := f.backend(true)
+	if err != nil {
+		return err
+	}
+	d, err := findDevice(b, args[0])
+	if err != nil {
+		return err
+	}
+
+	// Store every device as a group, like the web UI does when editing.
+	d.SubDevices = append([]storage.SubDevice(nil), deviceMembers(d)...)
+	if sf.given(f) {
+		if len(d.SubDevices) == 0 {
+			return errors.New("the group only references other devices; edit those instead")
+		}
+		if *index < 1 || *index > len(d.SubDevices) {
+			return fmt.Errorf("--index must be between 1 and %d", len(d.SubDevices))
+		}
+		sf.apply(f, &d.SubDevices[*index-1])
+	}
+	if *newName != "" {
+		d.Name = *newName
+	}
+	if *pingMode != "" {
+		d.PingMode = *pingMode
+	}
+	pf.apply(f, &d)
+
+	d, err = b.UpdateDevice(d.ID, d)
+	if err != nil {
+		return err
+	}
+	if *f.json {
+		return printJSON(d)
+	}
+	fmt.Printf("Device %q updated.\n", d.Name)
+	return nil
+}
+
+func cmdRm(args []string) error {
+	f := newCmdFlags("rm", "<device>... [options]")
+	purge := f.Bool("purge", false, "Delete for good instead of moving to the trash")
+	args, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		f.Usage()
+		return errors.New("device is required")
+	}
+
+	b, err := f.backend(true)
+	if err != nil {
+		return err
+	}
+	var deleted []string
+	for _, key := range args {
+		d, err := findDevice(b, key)
+		if err == nil {
+			err = b.DeleteDevice(d.ID)
+		}
+		if err == nil && *purge {
+			err = b.PurgeDevice(d.ID)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		deleted = append(deleted, d.ID)
+		if *f.json {
+			continue
+		}
+		if *purge {
+			fmt.Printf("Device %q deleted for good.\n", d.Name)
+		} else {
+			fmt.Printf("Device %q moved to the trash.\n", d.Name)
+		}
+	}
+	if *f.json {
+		return printJSON(map[string]interface{}{"deleted": deleted})
+	}
+	return nil
+}
+
+func cmdReorder(args []string) error {
+	f := newCmdFlags("reorder", "<device>... [options]")
+	args, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		f.Usage()
+		return errors.New("devices are required")
+	}
+
+	b, err := f.backend(true)
+	if err != nil {
+		return err
+	}
+	devices, err := b.Devices()
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for _, key := range args {
+		d, err := findIn(devices, key)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, d.ID)
+	}
+	if err := b.ReorderDevices(ids); err != nil {
+		return err
+	}
+	if *f.json {
+		return printJSON(ids)
+	}
+	fmt.Println("Devices reordered.")
+	return nil
+}
+
+// resolveDevice finds a device by ID, name, or the MAC of any of its members.
+func resolveDevice(devices []storage.Device, arg string) (storage.Device, error) {
+	if d, err := findIn(devices, arg); err == nil {
+		return d, nil
+	}
+
+	if _, err := net.ParseMAC(arg); err != nil {
+		return storage.Device{}, fmt.Errorf("device %q not found", arg)
+	}
+	if d, ok := storage.FindByMAC(devices, arg); ok {
+		return d, nil
+	}
+	return storage.Device{}, fmt.Errorf("no device with MAC %s, use wake-mac for machines that aren't saved", arg)
+}
+
+func cmdWake(args []string) error {
+	f := newCmdFlags("wake", "<device|mac>... [options]")
+	ff := addFilterFlags(f)
+	var proof storage.WakeProof
+	f.BoolVar(&proof.Confirm, "confirm", false, "Confirm waking protected devices")
+	f.StringVar(&proof.PIN, "pin", "", "PIN of protected devices")
+	args, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 && !ff.set() {
+		f.Usage()
+		return errors.New("device, MAC, --tag or --folder is required")
+	}
+
+	b, err := f.backend(true)
+	if err != nil {
+		return err
+	}
+	devices, err := b.Devices()
+	if err != nil {
+		return err
+	}
+	if ff.set() {
+		filter := ff.filter()
+		for _, d := range devices {
+			if filter.Match(d) {
+				args = append(args, d.ID)
+			}
+		}
+		if len(args) == 0 {
+			return errors.New("no devices match --tag/--folder")
+		}
+	}
+
+	type result struct {
+		ID      string `json:"id,omitempty"`
+		Device  string `json:"device"`
+		Message string `json:"message,omitempty"`
+		Error   string `json:"error,omitempty"`
+	}
+	var results []result
+	failed := false
+	for _, arg := range args {
+		r := result{Device: arg}
+		d, err := resolveDevice(devices, arg)
+		if err == nil {
+			r.ID, r.Device = d.ID, d.Name
+			r.Message, err = b.Wake(d.ID, proof)
+		}
+		if err != nil {
+			r.Error = err.Error()
+			failed = true
+		}
+		results = append(results, r)
+
+		if !*f.json {
+			if r.Error != "" {
+				fmt.Printf("%s: %s\n", r.Device, r.Error)
+			} else {
+				fmt.Printf("%s: %s\n", r.Device, r.Message)
+			}
+		}
+	}
+
+	if *f.json {
+		if err := printJSON(results); err != nil {
+			return err
+		}
+	}
+	if failed {
+		return errors.New("some wakes failed")
+	}
+	return nil
+}
+
+func cmdStatus(args []string) error {
+	f := newCmdFlags("status", "[device...] [options]")
+	args, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+
+	b, err := f.backend(true)
+	if err != nil {
+		return err
+	}
+	devices, err := b.Devices()
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		var selected []storage.Device
+		for _, key := range args {
+			d, err := findIn(devices, key)
+			if err != nil {
+				return err
+			}
+			selected = append(selected, d)
+		}
+		devices = selected
+	}
+
+	type result struct {
+		ID     string `json:"id"`
+		Device string `json:"device"`
+		client.Status
+		Error string `json:"error,omitempty"`
+	}
+	results := make([]result, len(devices))
+	var wg sync.WaitGroup
+	for i, d := range devices {
+		wg.Add(1)
+		go func(i int, d storage.Device) {
+			defer wg.Done()
+			status, err := b.Ping(d.ID)
+			results[i] = result{ID: d.ID, Device: d.Name, Status: status}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i, d)
+	}
+	wg.Wait()
+
+	if *f.json {
+		return printJSON(results)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATUS\tONLINE")
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			fmt.Fprintf(tw, "%s\tunknown\t%s\n", r.Device, r.Error)
+		case r.Online:
+			fmt.Fprintf(tw, "%s\tonline\t%d/%d\n", r.Device, r.OnlineCount, r.Total)
+		case r.Maintenance:
+			fmt.Fprintf(tw, "%s\tmaintenance\t%d/%d\n", r.Device, r.OnlineCount, r.Total)
+		default:
+			fmt.Fprintf(tw, "%s\toffline\t%d/%d\n", r.Device, r.OnlineCount, r.Total)
+		}
+	}
+	return tw.Flush()
+}
+
+func cmdLogs(args []string) error {
+	f := newCmdFlags("logs", "[options]")
+	device := f.String("device", "", "Only show entries for this device (ID or name)")
+	limit := f.Int("limit", 100, "Maximum number of entries")
+	if _, err := f.parse(args); err != nil {
+		return err
+	}
+
+	b, err := f.backend(true)
+	if err != nil {
+		return err
+	}
+	logs, err := b.Logs(*device, *limit)
+	if err != nil {
+		return err
+	}
+	if *f.json {
+		if logs == nil {
+			logs = []logger.LogEntry{}
+		}
+		return printJSON(logs)
+	}
+
+	// Print oldest first so the newest entry ends up next to the prompt.
+	for i := len(logs) - 1; i >= 0; i-- {
+		entry := logs[i]
+		if entry.Device != "" {
+			fmt.Printf("[%s] [%s] [%s] %s\n", entry.Timestamp, entry.Level, entry.Device, entry.Message)
+		} else {
+			fmt.Printf("[%s] [%s] %s\n", entry.Timestamp, entry.Level, entry.Message)
+		}
+	}
+	return nil
+}
+
+func cmdImport(args []string) error {
+	f := newCmdFlags("import", "<file|-> [options]")
+	format := f.String("format", "", "File format: "+strings.Join(devicefile.Formats, ", ")+" (default: from the file name)")
+	dryRun := f.Bool("dry-run", false, "Only show what would be added, updated and skipped")
+	args, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("exactly one file is required")
+	}
+	if *format == "" {
+		if *format = devicefile.FormatFromName(args[0]); *format == "" {
+			return errors.New("can't tell the format from the file name, use --format")
+		}
+	}
+
+	data, err := readInput(args[0])
+	if err != nil {
+		return err
+	}
+
+	b, err := f.backend(false)
+	if err != nil {
+		return err
+	}
+	plan, err := b.Import(*format, data, *dryRun)
+	if err != nil {
+		return err
+	}
+	if *f.json {
+		return printJSON(plan)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, d := range plan.Added {
+		fmt.Fprintf(tw, "add\t%s\t%s\n", d.Name, memberMACs(d))
+	}
+	for _, c := range plan.Updated {
+		fmt.Fprintf(tw, "update\t%s\t%s\n", c.New.Name, memberMACs(c.New))
+	}
+	for _, c := range plan.Conflicts {
+		fmt.Fprintf(tw, "skip\t%s\t%s\n", c.Device.Name, c.Reason)
+	}
+	tw.Flush()
+
+	verb := "Imported"
+	if plan.DryRun {
+		verb = "Dry run, nothing changed"
+	}
+	fmt.Printf("%s: %d added, %d updated, %d unchanged, %d skipped.\n",
+		verb, len(plan.Added), len(plan.Updated), plan.Unchanged, len(plan.Conflicts))
+	return nil
+}
+
+// readInput reads a file, or stdin for "-".
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+func cmdSync(args []string) error {
+	f := newCmdFlags("sync", "<file|-> [options]")
+	format := f.String("format", "", "File format: "+strings.Join(devicefile.Formats, ", ")+" (default: from the file name)")
+	dryRun := f.Bool("dry-run", false, "Only show which IPs would change")
+	args, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("exactly one file is required")
+	}
+	if *format == "" {
+		if *format = devicefile.FormatFromName(args[0]); *format == "" {
+			return errors.New("can't tell the format from the file name, use --format")
+		}
+	}
+	data, err := readInput(args[0])
+	if err != nil {
+		return err
+	}
+
+	b, err := f.backend(true)
+	if err != nil {
+		return err
+	}
+	changes, err := b.SyncIPs(*format, data, *dryRun)
+	if err != nil {
+		return err
+	}
+	if *f.json {
+		return printJSON(changes)
+	}
+
+	for _, c := range changes {
+		old := c.OldIP
+		if old == "" {
+			old = "-"
+		}
+		fmt.Printf("%s (%s): %s -> %s\n", c.Device, c.MAC, old, c.NewIP)
+	}
+	if *dryRun {
+		fmt.Printf("Dry run, nothing changed: %d IPs would be updated.\n", len(changes))
+	} else {
+		fmt.Printf("%d IPs updated.\n", len(changes))
+	}
+	return nil
+}
+
+// memberMACs lists the MAC addresses of a device's members.
+func memberMACs(d storage.Device) string {
+	var macs []string
+	for _, sub := range deviceMembers(d) {
+		macs = append(macs, sub.MAC)
+	}
+	return strings.Join(macs, ",")
+}
+
+func cmdExport(args []string) error {
+	f := newCmdFlags("export", "[options]")
+	format := f.String("format", "", "Output format: "+strings.Join(devicefile.ExportFormats, ", ")+" (default: from --output, else json)")
+	output := f.String("output", "", "Write to this file instead of stdout")
+	if _, err := f.parse(args); err != nil {
+		return err
+	}
+	if *format == "" {
+		if *format = devicefile.FormatFromName(*output); *format == "" {
+			*format = devicefile.JSON
+		}
+	}
+
+	b, err := f.backend(true)
+	if err != nil {
+		return err
+	}
+	data, err := b.Export(*format)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(*output, data, 0644)
+}
+
+// cmdNetwork lists and changes the networks members can be placed on.
+func cmdNetwork(args []string) error {
+	f := newCmdFlags("network", "list | add <name> [options] | edit <name> [options] | rm <name>")
+	cidr := f.String("cidr", "", `Subnet, e.g. "192.168.50.0/24"; members' broadcast address is derived from it`)
+	broadcast := f.String("broadcast", "", "Broadcast IP, instead of the one derived from --cidr")
+	iface := f.String("interface", "", `Send from this interface only, e.g. "eth1"`)
+	relay := f.String("relay", "", "Host[:port] that forwards magic packets into the subnet")
+	port := f.Int("port", 0, "Default UDP port of the members (default 9)")
+	newName := f.String("name", "", "Rename the network (edit)")
+	args, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		f.Usage()
+		return errors.New("subcommand is required")
+	}
+	if args[0] != "list" && len(args) != 2 {
+		f.Usage()
+		return errors.New("exactly one network name is required")
+	}
+
+	b, err := f.backend(args[0] != "add")
+	if err != nil {
+		return err
+	}
+	networks, err := b.Networks()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		if *f.json {
+			return printJSON(networks)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tCIDR\tBROADCAST\tINTERFACE\tRELAY\tPORT")
+		for _, n := range networks {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\n", n.Name, n.CIDR, n.BroadcastIP, n.Interface, n.Relay, n.Port)
+		}
+		return tw.Flush()
+	case "add", "edit":
+		var n storage.Network
+		if args[0] == "edit" {
+			i := slices.IndexFunc(networks, func(n storage.Network) bool { return n.Name == args[1] })
+			if i < 0 {
+				return fmt.Errorf("network %q not found", args[1])
+			}
+			n = networks[i]
+		} else {
+			n.Name = args[1]
+		}
+		f.Visit(func(fl *flag.Flag) {
+			switch fl.Name {
+			case "cidr":
+				n.CIDR = *cidr
+			case "broadcast":
+				n.BroadcastIP = *broadcast
+			case "interface":
+				n.Interface = *iface
+			case "relay":
+				n.Relay = *relay
+			case "port":
+				n.Port = *port
+			case "name":
+				n.Name = *newName
+			}
+		})
+		if args[0] == "add" {
+			n, err = b.AddNetwork(n)
+		} else {
+			n, err = b.UpdateNetwork(args[1], n)
+		}
+		if err != nil {
+			return err
+		}
+		if *f.json {
+			return printJSON(n)
+		}
+		if args[0] == "add" {
+			fmt.Printf("Network %q added.\n", n.Name)
+		} else {
+			fmt.Printf("Network %q updated.\n", n.Name)
+		}
+	case "rm":
+		if err := b.DeleteNetwork(args[1]); err != nil {
+			return err
+		}
+		if !*f.json {
+			fmt.Printf("Network %q removed.\n", args[1])
+		}
+	default:
+		return fmt.Errorf("unknown subcommand %q", args[0])
+	}
+	return nil
+}
+
+// cmdRemote manages the client config file that points the other commands at
+// a remote server instead of the local wol.json.
+func cmdRemote(args []string) error {
+	fs := flag.NewFlagSet("remote", flag.ContinueOnError)
+	token := fs.String("token", "", "API token of the server")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), "Usage: wol remote set <url> [--token TOKEN] | show | unset\n\nOptions:\n")
+		fs.PrintDefaults()
+	}
+	f := &cmdFlags{FlagSet: fs}
+	args, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return errors.New("subcommand is required")
+	}
+
+	path, err := client.DefaultConfigPath()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "set":
+		if len(args) != 2 {
+			return errors.New("usage: wol remote set <url> [--token TOKEN]")
+		}
+		cfg := client.Config{URL: args[1], Token: *token}
+		// Fail early on a wrong URL or token rather than on the next command.
+		if _, err := cfg.Client().Devices(); err != nil {
+			return fmt.Errorf("cannot reach %s: %v", cfg.URL, err)
+		}
+		if err := client.SaveConfig(path, cfg); err != nil {
+			return err
+		}
+		fmt.Printf("Remote server set to %s (saved in %s).\n", cfg.URL, path)
+	case "show":
+		cfg, err := client.LoadConfig(path)
+		if os.IsNotExist(err) {
+			fmt.Println("No remote server set; commands use the local config file.")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		tokenState := "none"
+		if cfg.Token != "" {
+			tokenState = "set"
+		}
+		fmt.Printf("URL:    %s\nToken:  %s\nConfig: %s\n", cfg.URL, tokenState, path)
+	case "unset":
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		fmt.Println("Remote server removed; commands use the local config file.")
+	default:
+		return fmt.Errorf("unknown subcommand %q", args[0])
+	}
+	return nil
+}
+
+func cmdHistory(args []string) error {
+	f := newCmdFlags("history", "[device|network] [options]")
+	limit := f.Int("limit", 50, "Maximum number of changes")
+	diff := f.Bool("diff", false, "Show the changed fields of each change")
+	args, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return errors.New("at most one device or network can be given")
+	}
+	key := ""
+	if len(args) == 1 {
+		key = args[0]
+	}
+
+	b, err := f.backend(true)
+	if err != nil {
+		return err
+	}
+	events, err := b.History(key, *limit)
+	if err != nil {
+		return err
+	}
+	if *f.json {
+		if events == nil {
+			events = []storage.AuditEvent{}
+		}
+		return printJSON(events)
+	}
+
+	// Print oldest first so the newest change ends up next to the prompt.
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tTIME\tACTOR\tCHANGE\tNOTE")
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		actor := e.Actor.Name
+		if e.IP != "" {
+			actor += " " + e.IP
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", e.ID, e.Time.Local().Format("2006-01-02 15:04:05"), actor, describeEvent(e), e.Note)
+		if *diff {
+			for _, c := range e.Diff {
+				fmt.Fprintf(w, "\t\t\t  %s: %v → %v\t\n", c.Field, formatValue(c.Old), formatValue(c.New))
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// formatValue prints a value from a change's diff, with "-" for none.
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "-"
+	}
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprint(v)
+}
+
+func cmdRevert(args []string) error {
+	f := newCmdFlags("revert", "<change>")
+	args, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		f.Usage()
+		return errors.New("exactly one change number is required (see wol history)")
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(args[0], "#"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid change number %q", args[0])
+	}
+
+	b, err := f.backend(true)
+	if err != nil {
+		return err
+	}
+	e, err := b.Revert(id)
+	if err != nil {
+		return err
+	}
+	if *f.json {
+		return printJSON(e)
+	}
+	fmt.Printf("Reverted #%d: %s\n", e.ID, describeEvent(e))
+	return nil
+}
+
+func cmdRestore(args []string) error {
+	f := newCmdFlags("restore", "<change|time>")
+	args, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		f.Usage()
+		return errors.New(`a change number or a time such as "2024-05-01 12:00" is required`)
+	}
+
+	b, err := f.backend(true)
+	if err != nil {
+		return err
+	}
+	var undone int
+	if id, parseErr := strconv.ParseInt(strings.TrimPrefix(args[0], "#"), 10, 64); parseErr == nil {
+		undone, err = b.Restore(id)
+	} else {
+		t, parseErr := parseTime(args[0])
+		if parseErr != nil {
+			return parseErr
+		}
+		undone, err = b.RestoreTime(t)
+	}
+	if err != nil {
+		return err
+	}
+	if *f.json {
+		return printJSON(map[string]int{"undone": undone})
+	}
+	fmt.Printf("Restored, %d changes undone\n", undone)
+	return nil
+}
+
+// parseTime accepts RFC 3339 and local times such as "2024-05-01 12:00".
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use e.g. \"2024-05-01 12:00\"", s)
+}
+
+func cmdTrash(args []string) error {
+	f := newCmdFlags("trash", "list | restore <device>... | purge <device>... | empty")
+	args, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	switch args[0] {
+	case "list", "empty":
+		if len(args) != 1 {
+			f.Usage()
+			return fmt.Errorf("%s takes no arguments", args[0])
+		}
+	case "restore", "purge":
+		if len(args) < 2 {
+			f.Usage()
+			return errors.New("device is required")
+		}
+	default:
+		f.Usage()
+		return fmt.Errorf("unknown subcommand %q", args[0])
+	}
+
+	b, err := f.backend(true)
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "list":
+		trash, err := b.Trash()
+		if err != nil {
+			return err
+		}
+		if *f.json {
+			if trash == nil {
+				trash = []storage.TrashedDevice{}
+			}
+			return printJSON(trash)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tDELETED\tBY")
+		for _, t := range trash {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.ID, t.Name, t.DeletedAt.Local().Format("2006-01-02 15:04:05"), t.DeletedBy)
+		}
+		return w.Flush()
+	case "empty":
+		if err := b.EmptyTrash(); err != nil {
+			return err
+		}
+		fmt.Println("Trash emptied.")
+		return nil
+	}
+
+	for _, key := range args[1:] {
+		if args[0] == "restore" {
+			d, err := b.RestoreDevice(key)
+			if err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			fmt.Printf("Device %q restored.\n", d.Name)
+		} else {
+			if err := b.PurgeDevice(key); err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			fmt.Printf("%s deleted for good.\n", key)
+		}
+	}
+	return nil
+}
+
+func cmdMaintenance(args []string) error {
+	f := newCmdFlags("maintenance", "<device> [options]")
+	reason := f.String("reason", "", `Why waking is blocked, e.g. "reimaging"`)
+	until := f.String("until", "", `End of the maintenance, e.g. "2024-05-01 18:00" (default: until --off)`)
+	forDuration := f.Duration("for", 0, `Length of the maintenance, e.g. "2h"`)
+	off := f.Bool("off", false, "End the maintenance")
+	args, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		f.Usage()
+		return errors.New("exactly one device is required")
+	}
+	if *until != "" && *forDuration != 0 {
+		return errors.New("--until and --for can't be combined")
+	}
+
+	var m storage.Maintenance
+	if !*off {
+		m.Reason = *reason
+		switch {
+		case *until != "":
+			t, err := parseTime(*until)
+			if err != nil {
+				return err
+			}
+			m.Until = &t
+		case *forDuration > 0:
+			t := time.Now().Add(*forDuration)
+			m.Until = &t
+		}
+	}
+
+	b, err := f.backend(true)
+	if err != nil {
+		return err
+	}
+	var d storage.Device
+	if *off {
+		d, err = b.ClearMaintenance(args[0])
+	} else {
+		d, err = b.SetMaintenance(args[0], m)
+	}
+	if err != nil {
+		return err
+	}
+	if *f.json {
+		return printJSON(d)
+	}
+	if d.Maintenance == nil {
+		fmt.Printf("Device %q is out of maintenance.\n", d.Name)
+	} else {
+		fmt.Printf("Device %q is in maintenance %s\n", d.Name, d.Maintenance)
+	}
+	return nil
+}
+
+func cmdProtect(args []string) error {
+	f := newCmdFlags("protect", "<device> [options]")
+	confirm := f.Bool("confirm", false, "Require wakes to be confirmed (default)")
+	pin := f.String("pin", "", "Require this PIN to wake")
+	keepPIN := f.Bool("keep-pin", false, "Require the current PIN, e.g. after protecting with --confirm")
+	off := f.Bool("off", false, "Remove the protection")
+	args, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		f.Usage()
+		return errors.New("exactly one device is required")
+	}
+	set := 0
+	for _, b := range []bool{*confirm, *pin != "", *keepPIN, *off} {
+		if b {
+			set++
+		}
+	}
+	if set > 1 {
+		return errors.New("--confirm, --pin, --keep-pin and --off can't be combined")
+	}
+
+	b, err := f.backend(true)
+	if err != nil {
+		return err
+	}
+	var d storage.Device
+	switch {
+	case *off:
+		d, err = b.ClearProtection(args[0])
+	case *pin != "" || *keepPIN:
+		d, err = b.SetProtection(args[0], storage.ProtectPIN, *pin)
+	default:
+		d, err = b.SetProtection(args[0], storage.ProtectConfirm, "")
+	}
+	if err != nil {
+		return err
+	}
+	if *f.json {
+		return printJSON(d)
+	}
+	switch {
+	case d.Protection == nil:
+		fmt.Printf("Device %q is no longer protected.\n", d.Name)
+	case d.Protection.Level == storage.ProtectPIN:
+		fmt.Printf("Waking %q now requires its PIN (--pin).\n", d.Name)
+	default:
+		fmt.Printf("Waking %q now requires --confirm.\n", d.Name)
+	}
+	return nil
+}
+
+func cmdWakeMAC(args []string) error {
+	f := newCmdFlags("wake-mac", "<mac> [options]")
+	var req client.WakeMACRequest
+	f.StringVar(&req.BroadcastIP, "broadcast", "", "Broadcast IP (default: every interface)")
+	f.IntVar(&req.Port, "port", 9, "UDP port")
+	f.StringVar(&req.SecureOn, "secureon", "", `SecureOn password, e.g. "01:02:03:04:05:06"`)
+	args, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		f.Usage()
+		return errors.New("exactly one MAC is required")
+	}
+	req.MAC = args[0]
+
+	b, err := f.backend(false)
+	if err != nil {
+		return err
+	}
+	msg, err := b.WakeMAC(req)
+	if err != nil {
+		return err
+	}
+	if *f.json {
+		return printJSON(map[string]string{"mac": req.MAC, "message": msg})
+	}
+	fmt.Println(msg)
+	return nil
+}
@@ -0,0 +1,363 @@
+// Package client is a Go client for the WOL Manager HTTP API.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"wol/logger"
+	"wol/storage"
+)
+
+// Status is the online state of a device as reported by /api/ping.
+type Status struct {
+	Online      bool   `json:"online"`
+	Total       int    `json:"total"`
+	OnlineCount int    `json:"online_count"`
+	Details     []bool `json:"details"`
+	Mode        string `json:"mode,omitempty"`
+	Maintenance bool   `json:"maintenance,omitempty"` // Waking is blocked, so being offline is expected
+}
+
+// WakeMACRequest wakes a machine by MAC, whether or not it is a saved
+// device.
+type WakeMACRequest struct {
+	MAC         string `json:"mac"`
+	BroadcastIP string `json:"broadcast_ip,omitempty"` // Every interface's broadcast address if empty
+	Port        int    `json:"port,omitempty"`         // Default 9
+	SecureOn    string `json:"secureon,omitempty"`     // Password, e.g. "01:02:03:04:05:06" or "192.168.1.1"
+}
+
+// Client talks to a running WOL Manager server.
+type Client struct {
+	baseURL string
+	// Token is sent as a Bearer token when the server has an api_token set.
+	Token string
+	// Actor is recorded in the server's audit log as who made changes.
+	Actor      string
+	HTTPClient *http.Client
+}
+
+// New returns a client for the server at baseURL, e.g. "http://nas:8888".
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Error is returned when the server answers with a non-2xx status.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// do sends a request. body is sent as is if it is a []byte, and as JSON
+// otherwise. out may be nil, a *string or *[]byte for the raw response, or a
+// value to decode the JSON response into.
+func (c *Client) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(body)
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if _, raw := body.([]byte); body != nil && !raw {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if c.Actor != "" {
+		req.Header.Set("X-WOL-Actor", c.Actor)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+
+	switch out := out.(type) {
+	case nil:
+		return nil
+	case *string:
+		*out = string(data)
+		return nil
+	case *[]byte:
+		*out = data
+		return nil
+	default:
+		return json.Unmarshal(data, out)
+	}
+}
+
+// Devices returns all configured devices in display order.
+func (c *Client) Devices() ([]storage.Device, error) {
+	var devices []storage.Device
+	err := c.do(http.MethodGet, "/api/devices", nil, &devices)
+	return devices, err
+}
+
+// AddDevice creates a new device and returns it with its generated IDs.
+func (c *Client) AddDevice(d storage.Device) (storage.Device, error) {
+	var added storage.Device
+	err := c.do(http.MethodPost, "/api/devices", d, &added)
+	return added, err
+}
+
+// Device returns the device with the given ID or name.
+func (c *Client) Device(key string) (storage.Device, error) {
+	var d storage.Device
+	err := c.do(http.MethodGet, "/api/devices/"+url.PathEscape(key), nil, &d)
+	return d, err
+}
+
+// UpdateDevice replaces the device with the given ID or name by d. The
+// device keeps its ID.
+func (c *Client) UpdateDevice(key string, d storage.Device) (storage.Device, error) {
+	var updated storage.Device
+	err := c.do(http.MethodPut, "/api/devices/"+url.PathEscape(key), d, &updated)
+	return updated, err
+}
+
+// DeleteDevice moves the device with the given ID or name to the trash.
+func (c *Client) DeleteDevice(key string) error {
+	return c.do(http.MethodDelete, "/api/devices/"+url.PathEscape(key), nil, nil)
+}
+
+// SetMaintenance puts the device with the given ID or name in maintenance,
+// blocking wakes until m.Until or until it is cleared.
+func (c *Client) SetMaintenance(key string, m storage.Maintenance) (storage.Device, error) {
+	var d storage.Device
+	err := c.do(http.MethodPut, "/api/devices/"+url.PathEscape(key)+"/maintenance", m, &d)
+	return d, err
+}
+
+// ClearMaintenance takes the device with the given ID or name out of
+// maintenance.
+func (c *Client) ClearMaintenance(key string) (storage.Device, error) {
+	var d storage.Device
+	err := c.do(http.MethodDelete, "/api/devices/"+url.PathEscape(key)+"/maintenance", nil, &d)
+	return d, err
+}
+
+// SetProtection requires a confirmation (level "confirm") or a PIN (level
+// "pin") to wake the device with the given ID or name. pin can be empty to
+// keep the current PIN.
+func (c *Client) SetProtection(key, level, pin string) (storage.Device, error) {
+	var d storage.Device
+	body := map[string]string{"level": level, "pin": pin}
+	err := c.do(http.MethodPut, "/api/devices/"+url.PathEscape(key)+"/protection", body, &d)
+	return d, err
+}
+
+// ClearProtection removes the wake protection of the device with the given ID
+// or name.
+func (c *Client) ClearProtection(key string) (storage.Device, error) {
+	var d storage.Device
+	err := c.do(http.MethodDelete, "/api/devices/"+url.PathEscape(key)+"/protection", nil, &d)
+	return d, err
+}
+
+// Trash returns the deleted devices that can still be restored, most
+// recently deleted first.
+func (c *Client) Trash() ([]storage.TrashedDevice, error) {
+	var trash []storage.TrashedDevice
+	err := c.do(http.MethodGet, "/api/trash", nil, &trash)
+	return trash, err
+}
+
+// RestoreDevice moves the trashed device with the given ID or name back to
+// the device list.
+func (c *Client) RestoreDevice(key string) (storage.Device, error) {
+	var d storage.Device
+	err := c.do(http.MethodPost, "/api/trash/"+url.PathEscape(key)+"/restore", nil, &d)
+	return d, err
+}
+
+// PurgeDevice removes the trashed device with the given ID or name for good.
+func (c *Client) PurgeDevice(key string) error {
+	return c.do(http.MethodDelete, "/api/trash/"+url.PathEscape(key), nil, nil)
+}
+
+// EmptyTrash removes every trashed device for good.
+func (c *Client) EmptyTrash() error {
+	return c.do(http.MethodDelete, "/api/trash", nil, nil)
+}
+
+// ReorderDevices sets the display order of all devices, given as IDs.
+func (c *Client) ReorderDevices(keys []string) error {
+	return c.do(http.MethodPost, "/api/devices/reorder", keys, nil)
+}
+
+// Networks returns the configured networks.
+func (c *Client) Networks() ([]storage.Network, error) {
+	var networks []storage.Network
+	err := c.do(http.MethodGet, "/api/networks", nil, &networks)
+	return networks, err
+}
+
+// AddNetwork creates a new network.
+func (c *Client) AddNetwork(n storage.Network) (storage.Network, error) {
+	var added storage.Network
+	err := c.do(http.MethodPost, "/api/networks", n, &added)
+	return added, err
+}
+
+// UpdateNetwork replaces the network with the given name by n. Members on it
+// follow a rename.
+func (c *Client) UpdateNetwork(name string, n storage.Network) (storage.Network, error) {
+	var updated storage.Network
+	err := c.do(http.MethodPut, "/api/networks/"+url.PathEscape(name), n, &updated)
+	return updated, err
+}
+
+// DeleteNetwork removes the network with the given name.
+func (c *Client) DeleteNetwork(name string) error {
+	return c.do(http.MethodDelete, "/api/networks/"+url.PathEscape(name), nil, nil)
+}
+
+// Wake sends magic packets to the device with the given ID or name and
+// returns the server's summary. proof is needed for protected devices.
+func (c *Client) Wake(key string, proof storage.WakeProof) (string, error) {
+	var msg string
+	err := c.do(http.MethodPost, "/api/wake/"+url.PathEscape(key), proof, &msg)
+	return msg, err
+}
+
+// WakeMAC sends magic packets to a machine that doesn't need to be a saved
+// device and returns the server's summary.
+func (c *Client) WakeMAC(req WakeMACRequest) (string, error) {
+	var msg string
+	err := c.do(http.MethodPost, "/api/wake-mac", req, &msg)
+	return msg, err
+}
+
+// Ping reports whether the device with the given ID or name is online.
+func (c *Client) Ping(key string) (Status, error) {
+	var status Status
+	err := c.do(http.MethodGet, "/api/ping/"+url.PathEscape(key), nil, &status)
+	return status, err
+}
+
+// Logs returns up to limit log entries, newest first, optionally filtered by
+// device ID or name.
+func (c *Client) Logs(device string, limit int) ([]logger.LogEntry, error) {
+	q := url.Values{}
+	if device != "" {
+		q.Set("device", device)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var logs []logger.LogEntry
+	err := c.do(http.MethodGet, "/api/logs?"+q.Encode(), nil, &logs)
+	return logs, err
+}
+
+// Import merges a device list in the given format (see package devicefile)
+// into the server's config. With dryRun nothing is changed and the returned
+// plan shows what would be.
+func (c *Client) Import(format string, data []byte, dryRun bool) (storage.ImportPlan, error) {
+	q := url.Values{}
+	q.Set("format", format)
+	if dryRun {
+		q.Set("dry_run", "1")
+	}
+	var plan storage.ImportPlan
+	err := c.do(http.MethodPost, "/api/import?"+q.Encode(), data, &plan)
+	return plan, err
+}
+
+// SyncIPs refreshes device IPs by MAC from a DHCP lease or reservation file
+// in the given format. With dryRun nothing is changed.
+func (c *Client) SyncIPs(format string, data []byte, dryRun bool) ([]storage.IPChange, error) {
+	q := url.Values{}
+	q.Set("format", format)
+	if dryRun {
+		q.Set("dry_run", "1")
+	}
+	var changes []storage.IPChange
+	err := c.do(http.MethodPost, "/api/sync?"+q.Encode(), data, &changes)
+	return changes, err
+}
+
+// Export returns all devices in the given format.
+func (c *Client) Export(format string) ([]byte, error) {
+	var data []byte
+	err := c.do(http.MethodGet, "/api/export?format="+url.QueryEscape(format), nil, &data)
+	return data, err
+}
+
+// History returns up to limit recorded config changes, newest first,
+// optionally only those to one device (ID or name) or network.
+func (c *Client) History(key string, limit int) ([]storage.AuditEvent, error) {
+	q := url.Values{}
+	if key != "" {
+		q.Set("key", key)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var events []storage.AuditEvent
+	err := c.do(http.MethodGet, "/api/audit?"+q.Encode(), nil, &events)
+	return events, err
+}
+
+// Revert undoes the recorded change with the given ID and returns it.
+func (c *Client) Revert(id int64) (storage.AuditEvent, error) {
+	var e storage.AuditEvent
+	err := c.do(http.MethodPost, "/api/audit/"+strconv.FormatInt(id, 10)+"/revert", nil, &e)
+	return e, err
+}
+
+// Restore returns the whole config to how it was right after the recorded
+// change with the given ID, 0 meaning before every recorded change, and
+// returns the number of changes undone.
+func (c *Client) Restore(id int64) (int, error) {
+	return c.restore("id=" + strconv.FormatInt(id, 10))
+}
+
+// RestoreTime returns the whole config to how it was at t.
+func (c *Client) RestoreTime(t time.Time) (int, error) {
+	return c.restore("time=" + url.QueryEscape(t.Format(time.RFC3339)))
+}
+
+func (c *Client) restore(query string) (int, error) {
+	var result struct {
+		Undone int `json:"undone"`
+	}
+	err := c.do(http.MethodPost, "/api/audit/restore?"+query, nil, &result)
+	return result.Undone, err
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// Config is the client config file telling the CLI which server to talk to.
+type Config struct {
+	URL   string `json:"url"`
+	Token string `json:"token,omitempty"`
+}
+
+// DefaultConfigPath returns the client config location, e.g.
+// ~/.config/wol/client.json on Linux. WOL_CLIENT_CONFIG overrides it.
+func DefaultConfigPath() (string, error) {
+	if path := os.Getenv("WOL_CLIENT_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "wol", "client.json"), nil
+}
+
+// LoadConfig reads a client config file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, err
+	}
+	if cfg.URL == "" {
+		return cfg, errors.New("client config has no url")
+	}
+	return cfg, nil
+}
+
+// SaveConfig writes a client config file. It is only readable by the owner
+// because it may hold a token.
+func SaveConfig(path string, cfg Config) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// Client returns a client for the configured server.
+func (cfg Config) Client() *Client {
+	c := New(cfg.URL)
+	c.Token = cfg.Token
+	return c
+}
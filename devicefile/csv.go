@@ -0,0 +1,107 @@
+package devicefile
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"wol/storage"
+)
+
+// csvColumns is the column order written, and assumed when a file has no
+// header row.
+var csvColumns = []string{"name", "mac", "ip", "port", "broadcast", "remark", "group"}
+
+// readCSV reads one member per row. Rows with a group become members of the
+// device named by the group, with the name column used as the remark if the
+// row has none; other rows are devices of their own. A header row naming the
+// columns is optional and allows any column order.
+func readCSV(r io.Reader) ([]storage.Device, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+
+	col := make(map[string]int)
+	for i, name := range csvColumns {
+		col[name] = i
+	}
+
+	var devices []storage.Device
+	groups := make(map[string]int) // Group name -> index in devices
+	for first := true; ; first = false {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return devices, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+
+		if first && strings.EqualFold(strings.TrimSpace(rec[0]), "name") {
+			col = make(map[string]int)
+			for i, name := range rec {
+				col[strings.ToLower(strings.TrimSpace(name))] = i
+			}
+			if _, ok := col["mac"]; !ok {
+				return nil, errors.New("header has no mac column")
+			}
+			continue
+		}
+
+		field := func(name string) string {
+			if i, ok := col[name]; ok && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		sub := storage.SubDevice{
+			MAC:         field("mac"),
+			IP:          field("ip"),
+			BroadcastIP: field("broadcast"),
+			Remark:      field("remark"),
+		}
+		if p := field("port"); p != "" {
+			if sub.Port, err = strconv.Atoi(p); err != nil {
+				return nil, fmt.Errorf("line %d: invalid port %q", line, p)
+			}
+		}
+
+		group := field("group")
+		if group == "" {
+			devices = append(devices, storage.Device{Name: field("name"), SubDevices: []storage.SubDevice{sub}})
+			continue
+		}
+		if sub.Remark == "" && field("name") != group {
+			sub.Remark = field("name")
+		}
+		if i, ok := groups[group]; ok {
+			devices[i].SubDevices = append(devices[i].SubDevices, sub)
+		} else {
+			groups[group] = len(devices)
+			devices = append(devices, storage.Device{Name: group, SubDevices: []storage.SubDevice{sub}})
+		}
+	}
+}
+
+// writeCSV writes a header and one row per member. Members of groups carry
+// the group's name in both the name and group columns.
+func writeCSV(w io.Writer, devices []storage.Device) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvColumns)
+	for _, d := range devices {
+		group := ""
+		if len(d.SubDevices) > 1 {
+			group = d.Name
+		}
+		for _, sub := range d.SubDevices {
+			cw.Write([]string{d.Name, sub.MAC, sub.IP, strconv.Itoa(sub.Port), sub.BroadcastIP, sub.Remark, group})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,176 @@
+// Package devicefile reads and writes device lists in the native JSON format
+// and in formats used by other tools, for bulk import and export.
+package devicefile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"wol/storage"
+)
+
+// Supported formats.
+const (
+	JSON          = "json"           // The devices array of wol.json, or the whole file
+	CSV           = "csv"            // name,mac,ip,port,broadcast,remark,group
+	Ethers        = "ethers"         // /etc/ethers: "MAC host" per line
+	Dnsmasq       = "dnsmasq"        // dhcp-host=MAC,IP,hostname lines
+	DnsmasqLeases = "dnsmasq-leases" // dnsmasq.leases, read only
+	ISC           = "isc"            // ISC dhcpd.leases or dhcpd.conf host blocks, read only
+	Kea           = "kea"            // Kea memfile leases or config reservations, read only
+)
+
+// Formats lists the formats Read accepts.
+var Formats = []string{JSON, CSV, Ethers, Dnsmasq, DnsmasqLeases, ISC, Kea}
+
+// ExportFormats lists the formats Write produces.
+var ExportFormats = []string{JSON, CSV, Ethers, Dnsmasq}
+
+// FormatFromName guesses the format from a file name, or returns "".
+func FormatFromName(filename string) string {
+	base := strings.ToLower(filepath.Base(filename))
+	switch {
+	case strings.Contains(base, "dhcpd"):
+		return ISC
+	case strings.Contains(base, "kea"):
+		return Kea
+	case strings.Contains(base, "dnsmasq") && strings.HasSuffix(base, ".leases"):
+		return DnsmasqLeases
+	case strings.HasSuffix(base, ".json"):
+		return JSON
+	case strings.HasSuffix(base, ".csv"):
+		return CSV
+	case base == "ethers" || strings.HasSuffix(base, ".ethers"):
+		return Ethers
+	case strings.HasSuffix(base, ".conf") || strings.Contains(base, "dnsmasq"):
+		return Dnsmasq
+	}
+	return ""
+}
+
+// ContentType returns the MIME type of a format.
+func ContentType(format string) string {
+	switch format {
+	case JSON:
+		return "application/json"
+	case CSV:
+		return "text/csv; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Extension returns the file name extension used for a format, e.g. when
+// downloading an export.
+func Extension(format string) string {
+	switch format {
+	case JSON, CSV:
+		return "." + format
+	}
+	return ".conf"
+}
+
+// Read parses a device list. Fields a format doesn't carry are left empty,
+// and so is the port, which storage.Store.Import then defaults to 9 for new
+// members and leaves alone for existing ones.
+func Read(format string, r io.Reader) ([]storage.Device, error) {
+	switch format {
+	case JSON:
+		return readJSON(r)
+	case CSV:
+		return readCSV(r)
+	case Ethers:
+		return readEthers(r)
+	case Dnsmasq:
+		return readDnsmasq(r)
+	case DnsmasqLeases:
+		return readDnsmasqLeases(r)
+	case ISC:
+		return readISC(r)
+	case Kea:
+		return readKea(r)
+	}
+	return nil, unknownFormat(format, Formats)
+}
+
+// Write formats devices. Formats other than JSON only keep what they can
+// represent.
+func Write(format string, w io.Writer, devices []storage.Device) error {
+	switch format {
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(devices)
+	case CSV:
+		return writeCSV(w, devices)
+	case Ethers:
+		return writeEthers(w, devices)
+	case Dnsmasq:
+		return writeDnsmasq(w, devices)
+	}
+	return unknownFormat(format, ExportFormats)
+}
+
+func unknownFormat(format string, supported []string) error {
+	return fmt.Errorf("unsupported format %q (supported: %s)", format, strings.Join(supported, ", "))
+}
+
+// readJSON accepts a devices array as returned by /api/devices, or a whole
+// wol.json.
+func readJSON(r io.Reader) ([]storage.Device, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var devices []storage.Device
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var cfg struct {
+			Devices []storage.Device `json:"devices"`
+		}
+		err = json.Unmarshal(data, &cfg)
+		devices = cfg.Devices
+	} else {
+		err = json.Unmarshal(data, &devices)
+	}
+	return devices, err
+}
+
+// lines calls fn for each line with comments and surrounding blanks removed,
+// skipping empty ones.
+func lines(r io.Reader, fn func(n int, line string) error) error {
+	sc := bufio.NewScanner(r)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if err := fn(n, line); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return sc.Err()
+}
+
+// hostname turns a device name into something usable as a host name.
+func hostname(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('-')
+		}
+	}
+	return strings.Trim(b.String(), "-.")
+}
@@ -0,0 +1,139 @@
+package devicefile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+
+	"wol/storage"
+)
+
+// readEthers reads /etc/ethers, "MAC host" per line, where host is a host
+// name or IP. The host becomes both the device name and the address pinged.
+func readEthers(r io.Reader) ([]storage.Device, error) {
+	var devices []storage.Device
+	err := lines(r, func(n int, line string) error {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return errors.New("expected a MAC address and a host")
+		}
+		if _, err := net.ParseMAC(fields[0]); err != nil {
+			return err
+		}
+		devices = append(devices, storage.Device{
+			Name:       fields[1],
+			SubDevices: []storage.SubDevice{{MAC: fields[0], IP: fields[1]}},
+		})
+		return nil
+	})
+	return devices, err
+}
+
+// writeEthers writes one line per member, with its IP, or the device name as
+// host name if it has none.
+func writeEthers(w io.Writer, devices []storage.Device) error {
+	for _, d := range devices {
+		for _, sub := range d.SubDevices {
+			host := sub.IP
+			if host == "" {
+				host = hostname(d.Name)
+			}
+			if host == "" {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "%s %s\n", sub.MAC, host); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// leaseTime matches the lease time field of dhcp-host, e.g. "12h".
+var leaseTime = regexp.MustCompile(`^\d+[smhdw]?$`)
+
+// readDnsmasq reads dhcp-host entries, with or without the "dhcp-host="
+// prefix, so a whole dnsmasq.conf can be given; other options are skipped.
+// Several MACs on one line become members of one device, named by the host
+// name, or the IP if there is none.
+func readDnsmasq(r io.Reader) ([]storage.Device, error) {
+	var devices []storage.Device
+	err := lines(r, func(n int, line string) error {
+		line = strings.TrimPrefix(line, "--")
+		if strings.HasPrefix(line, "dhcp-host=") {
+			line = strings.TrimPrefix(line, "dhcp-host=")
+		} else if strings.Contains(line, "=") {
+			return nil
+		}
+
+		var macs []string
+		var ip, host string
+		for _, tok := range strings.Split(line, ",") {
+			tok = strings.TrimSpace(tok)
+			switch {
+			case tok == "", tok == "ignore", tok == "infinite", leaseTime.MatchString(tok):
+			case strings.HasPrefix(tok, "set:"), strings.HasPrefix(tok, "tag:"),
+				strings.HasPrefix(tok, "id:"), strings.HasPrefix(tok, "net:"),
+				strings.HasPrefix(tok, "["): // Client IDs, tags and IPv6
+			case isMAC(tok):
+				macs = append(macs, tok)
+			case net.ParseIP(tok) != nil:
+				ip = tok
+			case host == "" && !strings.Contains(tok, "*"):
+				host = tok
+			}
+		}
+		if len(macs) == 0 {
+			return nil // Matched by client ID or tag only
+		}
+
+		d := storage.Device{Name: host}
+		if d.Name == "" {
+			d.Name = ip
+		}
+		if ip == "" {
+			ip = host
+		}
+		for _, mac := range macs {
+			d.SubDevices = append(d.SubDevices, storage.SubDevice{MAC: mac, IP: ip})
+		}
+		devices = append(devices, d)
+		return nil
+	})
+	return devices, err
+}
+
+// writeDnsmasq writes a dhcp-host line per member. Only single devices get a
+// host name, as the members of a group are different machines.
+func writeDnsmasq(w io.Writer, devices []storage.Device) error {
+	for _, d := range devices {
+		for _, sub := range d.SubDevices {
+			fields := []string{sub.MAC}
+			host := ""
+			if net.ParseIP(sub.IP) != nil {
+				fields = append(fields, sub.IP)
+			} else {
+				host = hostname(sub.IP)
+			}
+			if len(d.SubDevices) == 1 && hostname(d.Name) != "" {
+				host = hostname(d.Name)
+			}
+			if host != "" {
+				fields = append(fields, host)
+			}
+			if _, err := fmt.Fprintf(w, "dhcp-host=%s\n", strings.Join(fields, ",")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isMAC accepts MAC addresses as dnsmasq writes them, without wildcards.
+func isMAC(s string) bool {
+	_, err := net.ParseMAC(s)
+	return err == nil
+}
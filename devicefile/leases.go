@@ -0,0 +1,259 @@
+package devicefile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+
+	"wol/storage"
+)
+
+// lease is one MAC to IP mapping from a DHCP server's files.
+type lease struct {
+	mac, ip, hostname string
+}
+
+// leaseDevices turns leases into one device per MAC. Later entries win, as
+// lease files are appended to.
+func leaseDevices(leases []lease) []storage.Device {
+	index := make(map[string]int)
+	var devices []storage.Device
+	for _, l := range leases {
+		hw, err := net.ParseMAC(l.mac)
+		if err != nil || l.ip == "" {
+			continue
+		}
+		name := l.hostname
+		if name == "" {
+			name = l.ip
+		}
+		d := storage.Device{
+			Name:       name,
+			SubDevices: []storage.SubDevice{{MAC: l.mac, IP: l.ip}},
+		}
+		if i, ok := index[hw.String()]; ok {
+			devices[i] = d
+		} else {
+			index[hw.String()] = len(devices)
+			devices = append(devices, d)
+		}
+	}
+	return devices
+}
+
+// readDnsmasqLeases reads dnsmasq.leases: "expiry MAC IP hostname client-id"
+// per line, with "*" for an unknown host name.
+func readDnsmasqLeases(r io.Reader) ([]storage.Device, error) {
+	var leases []lease
+	err := lines(r, func(n int, line string) error {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			if strings.HasPrefix(line, "duid") {
+				return nil // DHCPv6 server ID
+			}
+			return errors.New("expected expiry, MAC, IP and host name")
+		}
+		l := lease{mac: fields[1], ip: fields[2]}
+		if fields[3] != "*" {
+			l.hostname = fields[3]
+		}
+		if net.ParseIP(l.ip).To4() != nil {
+			leases = append(leases, l)
+		}
+		return nil
+	})
+	return leaseDevices(leases), err
+}
+
+// The statements of dhcpd.leases and dhcpd.conf that matter here.
+var (
+	iscBlock    = regexp.MustCompile(`^(lease|host)\s+("[^"]*"|\S+)\s*\{`)
+	iscHardware = regexp.MustCompile(`^hardware\s+ethernet\s+([0-9A-Fa-f:]+)`)
+	iscFixed    = regexp.MustCompile(`^fixed-address\s+([^;,\s]+)`)
+	iscHostname = regexp.MustCompile(`^client-hostname\s+"([^"]*)"`)
+	iscBinding  = regexp.MustCompile(`^binding\s+state\s+(\w+)`)
+)
+
+// readISC reads ISC dhcpd files: the lease blocks of dhcpd.leases, keeping
+// active leases, and the host reservations of dhcpd.conf.
+func readISC(r io.Reader) ([]storage.Device, error) {
+	var leases []lease
+	var cur *lease
+	var kind, state string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		// Statements may share a line with the braces around them
+		for _, stmt := range strings.Split(line, ";") {
+			stmt = strings.TrimSpace(stmt)
+			if m := iscBlock.FindStringSubmatch(stmt); m != nil {
+				kind, state = m[1], ""
+				cur = &lease{}
+				name := strings.Trim(m[2], `"`)
+				if kind == "lease" {
+					cur.ip = name
+				} else {
+					cur.hostname = name
+				}
+				stmt = strings.TrimSpace(stmt[len(m[0]):])
+			}
+			if cur == nil {
+				continue
+			}
+			switch {
+			case strings.HasPrefix(stmt, "}"):
+				if kind == "host" || state == "" || state == "active" {
+					leases = append(leases, *cur)
+				}
+				cur = nil
+			case iscHardware.MatchString(stmt):
+				cur.mac = iscHardware.FindStringSubmatch(stmt)[1]
+			case kind == "host" && iscFixed.MatchString(stmt):
+				cur.ip = iscFixed.FindStringSubmatch(stmt)[1]
+			case kind == "lease" && iscHostname.MatchString(stmt):
+				cur.hostname = iscHostname.FindStringSubmatch(stmt)[1]
+			case iscBinding.MatchString(stmt):
+				state = iscBinding.FindStringSubmatch(stmt)[1]
+			}
+		}
+	}
+	return leaseDevices(leases), sc.Err()
+}
+
+// readKea reads the memfile lease database of Kea (kea-leases4.csv), or the
+// host reservations of its JSON config (kea-dhcp4.conf), global or per
+// subnet.
+func readKea(r io.Reader) ([]storage.Device, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '/' || trimmed[0] == '#') {
+		return readKeaConfig(data)
+	}
+
+	cr := csv.NewReader(bytes.NewReader(data))
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	col := make(map[string]int)
+	for i, name := range header {
+		col[name] = i
+	}
+	for _, name := range []string{"address", "hwaddr"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("lease file has no %s column", name)
+		}
+	}
+
+	var leases []lease
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := col[name]; ok && i < len(rec) {
+				return rec[i]
+			}
+			return ""
+		}
+		if s := field("state"); s != "" && s != "0" {
+			continue // Declined or expired
+		}
+		leases = append(leases, lease{
+			mac:      field("hwaddr"),
+			ip:       field("address"),
+			hostname: strings.TrimSuffix(field("hostname"), "."),
+		})
+	}
+	return leaseDevices(leases), nil
+}
+
+// readKeaConfig collects every "reservations" list in a Kea config.
+func readKeaConfig(data []byte) ([]storage.Device, error) {
+	var cfg interface{}
+	if err := json.Unmarshal(stripComments(data), &cfg); err != nil {
+		return nil, err
+	}
+
+	var leases []lease
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				if key == "reservations" {
+					list, _ := child.([]interface{})
+					for _, item := range list {
+						res, _ := item.(map[string]interface{})
+						mac, _ := res["hw-address"].(string)
+						ip, _ := res["ip-address"].(string)
+						host, _ := res["hostname"].(string)
+						leases = append(leases, lease{mac: mac, ip: ip, hostname: host})
+					}
+					continue
+				}
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(cfg)
+	return leaseDevices(leases), nil
+}
+
+// stripComments removes the #, // and /* */ comments Kea allows in its JSON
+// config, leaving strings alone.
+func stripComments(data []byte) []byte {
+	var out []byte
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString:
+			out = append(out, c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out = append(out, data[i])
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '#', c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			out = append(out, '\n')
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return out
+			}
+			i += end + 3
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
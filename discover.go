@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"wol/discover"
+	"wol/logger"
+)
+
+// discovered is a host found by a scan, with the device that already has its
+// MAC, if any.
+type discovered struct {
+	discover.Host
+	DeviceID string `json:"device_id,omitempty"`
+	Device   string `json:"device,omitempty"`
+}
+
+// handleDiscoverSubnets lists the networks a scan can be run on.
+func handleDiscoverSubnets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	subnets, err := discover.LocalSubnets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(subnets)
+}
+
+// handleDiscover scans the subnet given as {"subnet": "192.168.1.0/24"} and
+// returns the hosts found. Nothing is added; that's left to the caller.
+func handleDiscover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Subnet string `json:"subnet"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, done, ok := jobs.Start()
+	if !ok {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer done()
+
+	hosts, err := discover.Scan(ctx, req.Subnet)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	found := make([]discovered, len(hosts))
+	for i, h := range hosts {
+		found[i].Host = h
+		if d, ok := store.FindByMAC(h.MAC); ok {
+			found[i].DeviceID, found[i].Device = d.ID, d.Name
+		}
+	}
+	logger.Info("System", fmt.Sprintf("Scanned %s: %d hosts found", req.Subnet, len(hosts)))
+	json.NewEncoder(w).Encode(found)
+}
+
+// handleResolve looks up the MAC of ?host=, an IP address or host name on an
+// attached network, so a device can be added from its address alone.
+func handleResolve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	host := r.URL.Query().Get("host")
+	if host == "" {
+		http.Error(w, "host is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, done, ok := jobs.Start()
+	if !ok {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer done()
+
+	h, err := discover.Resolve(ctx, host)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, discover.ErrNoReply) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	found := discovered{Host: h}
+	if d, ok := store.FindByMAC(h.MAC); ok {
+		found.DeviceID, found.Device = d.ID, d.Name
+	}
+	json.NewEncoder(w).Encode(found)
+}
@@ -0,0 +1,179 @@
+// Package discover finds the hosts on a directly attached IPv4 network by
+// probing every address and reading the ARP cache, and names them by reverse
+// DNS, multicast DNS and the vendor of their network card.
+package discover
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"wol/oui"
+)
+
+// maxHostBits bounds the size of a scan to a /22, so a sweep neither takes
+// long nor floods the kernel's neighbor table.
+const maxHostBits = 10
+
+// settle is how long to wait after probing for ARP replies to come in.
+const settle = 2 * time.Second
+
+// Host is a machine found on the network.
+type Host struct {
+	IP       string `json:"ip"`
+	MAC      string `json:"mac"`
+	Hostname string `json:"hostname,omitempty"`
+	Vendor   string `json:"vendor,omitempty"`
+}
+
+// Subnet is an IPv4 network attached to one of this machine's interfaces.
+type Subnet struct {
+	CIDR      string `json:"cidr"`
+	Interface string `json:"interface"`
+}
+
+// neighbor is an entry of the kernel's ARP cache.
+type neighbor struct {
+	ip  net.IP
+	mac net.HardwareAddr
+}
+
+// LocalSubnets lists the IPv4 networks of the interfaces that are up, except
+// loopback ones.
+func LocalSubnets() ([]Subnet, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	subnets := []Subnet{}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.To4() == nil || ipnet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			network := &net.IPNet{IP: ipnet.IP.Mask(ipnet.Mask), Mask: ipnet.Mask}
+			subnets = append(subnets, Subnet{CIDR: network.String(), Interface: iface.Name})
+		}
+	}
+	return subnets, nil
+}
+
+// Scan probes every address of subnet and returns the hosts that answered,
+// sorted by IP. The subnet must be IPv4, at most a /22, and attached to this
+// machine: hosts behind a router never show up in the ARP cache.
+func Scan(ctx context.Context, subnet string) ([]Host, error) {
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, err
+	}
+	if ipnet.IP.To4() == nil {
+		return nil, errors.New("only IPv4 subnets can be scanned")
+	}
+	ones, bits := ipnet.Mask.Size()
+	if bits-ones > maxHostBits {
+		return nil, fmt.Errorf("%s is too large to scan, use a /%d or smaller", subnet, bits-maxHostBits)
+	}
+	if !attached(ipnet) {
+		return nil, fmt.Errorf("%s is %w, hosts behind a router can't be discovered", subnet, ErrOffSubnet)
+	}
+
+	if err := probe(ctx, ipnet); err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(settle):
+	}
+
+	neighbors, err := neighbors()
+	if err != nil {
+		return nil, err
+	}
+	hosts := []Host{}
+	seen := make(map[string]bool)
+	for _, n := range neighbors {
+		if !ipnet.Contains(n.ip) || seen[n.ip.String()] {
+			continue
+		}
+		seen[n.ip.String()] = true
+		hosts = append(hosts, Host{
+			IP:     n.ip.String(),
+			MAC:    n.mac.String(),
+			Vendor: oui.Lookup(n.mac.String()),
+		})
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(hosts[i].IP).To4(), net.ParseIP(hosts[j].IP).To4()) < 0
+	})
+
+	resolveNames(ctx, hosts)
+	return hosts, nil
+}
+
+// attached reports whether ipnet overlaps a network of a local interface.
+func attached(ipnet *net.IPNet) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		local, ok := addr.(*net.IPNet)
+		if ok && !local.IP.IsLoopback() && (local.Contains(ipnet.IP) || ipnet.Contains(local.IP)) {
+			return true
+		}
+	}
+	return false
+}
+
+// probe sends an empty datagram to the discard port of every host address
+// of ipnet. Nothing needs to listen there: sending makes the kernel resolve
+// each address by ARP, and the hosts that reply land in the ARP cache.
+func probe(ctx context.Context, ipnet *net.IPNet) error {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	base := ipnet.IP.To4()
+	ones, bits := ipnet.Mask.Size()
+	size := uint32(1) << (bits - ones)
+	first, last := uint32(1), size-2
+	if size <= 2 {
+		first, last = 0, size-1 // /31 and /32 have no network or broadcast address
+	}
+	start := uint32(base[0])<<24 | uint32(base[1])<<16 | uint32(base[2])<<8 | uint32(base[3])
+	for i := first; i <= last; i++ {
+		n := start + i
+		addr := &net.UDPAddr{IP: net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n)), Port: 9}
+		// Unresolvable addresses fail later on, or not at all; that's the point
+		conn.WriteToUDP(nil, addr)
+		if i%64 == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	return nil
+}
+
+// usable reports whether mac belongs to a single host, rather than being
+// empty, broadcast or multicast.
+func usable(mac net.HardwareAddr) bool {
+	return len(mac) == 6 && mac[0]&0x01 == 0 && !bytes.Equal(mac, make(net.HardwareAddr, 6))
+}
@@ -0,0 +1,193 @@
+package discover
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nameTimeout bounds each of the reverse DNS and multicast DNS rounds.
+const nameTimeout = 2 * time.Second
+
+// resolveNames fills in the host names of hosts, first by reverse DNS and
+// then, for those left without one, by multicast DNS.
+func resolveNames(ctx context.Context, hosts []Host) {
+	dnsCtx, cancel := context.WithTimeout(ctx, nameTimeout)
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 16)
+	for i := range hosts {
+		wg.Add(1)
+		go func(h *Host) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if names, err := net.DefaultResolver.LookupAddr(dnsCtx, h.IP); err == nil && len(names) > 0 {
+				h.Hostname = strings.TrimSuffix(names[0], ".")
+			}
+		}(&hosts[i])
+	}
+	wg.Wait()
+	cancel()
+
+	var unnamed []string
+	for _, h := range hosts {
+		if h.Hostname == "" {
+			unnamed = append(unnamed, h.IP)
+		}
+	}
+	names := mdnsNames(ctx, unnamed)
+	for i := range hosts {
+		if name, ok := names[hosts[i].IP]; ok {
+			hosts[i].Hostname = name
+		}
+	}
+}
+
+// mdnsGroup is the multicast DNS address for IPv4.
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// mdnsNames asks for the names of ips by multicast DNS and returns those
+// answered within nameTimeout. Queries are sent from an ephemeral port, so
+// responders answer by unicast (RFC 6762 section 6.7).
+func mdnsNames(ctx context.Context, ips []string) map[string]string {
+	names := make(map[string]string)
+	if len(ips) == 0 {
+		return names
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return names
+	}
+	defer conn.Close()
+
+	wanted := make(map[string]string)
+	for _, ip := range ips {
+		reverse, err := reverseName(ip)
+		if err != nil {
+			continue
+		}
+		wanted[reverse] = ip
+		conn.WriteToUDP(ptrQuery(reverse), mdnsGroup)
+	}
+
+	// Unblock the read below when ctx is done before the deadline
+	ctx, cancel := context.WithTimeout(ctx, nameTimeout)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		conn.SetReadDeadline(time.Now())
+	}()
+
+	buf := make([]byte, 9000)
+	for len(names) < len(wanted) {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		for owner, target := range ptrAnswers(buf[:n]) {
+			if ip, ok := wanted[owner]; ok {
+				names[ip] = target
+			}
+		}
+	}
+	return names
+}
+
+// reverseName returns the in-addr.arpa name of an IPv4 address.
+func reverseName(ip string) (string, error) {
+	v4 := net.ParseIP(ip).To4()
+	if v4 == nil {
+		return "", fmt.Errorf("%s is not an IPv4 address", ip)
+	}
+	return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", v4[3], v4[2], v4[1], v4[0]), nil
+}
+
+// ptrQuery builds a DNS query for the PTR record of name.
+func ptrQuery(name string) []byte {
+	msg := make([]byte, 12, 64)
+	binary.BigEndian.PutUint16(msg[4:], 1) // One question
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0, 0, 12, 0, 1) // Root, type PTR, class IN
+}
+
+// ptrAnswers returns the PTR records of a DNS response, owner name to target
+// name, both lower case and without the trailing dot.
+func ptrAnswers(msg []byte) map[string]string {
+	records := make(map[string]string)
+	if len(msg) < 12 {
+		return records
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := 12
+	for i := 0; i < questions; i++ {
+		_, next, ok := readName(msg, off)
+		if !ok {
+			return records
+		}
+		off = next + 4 // Type and class
+	}
+	for i := 0; i < answers; i++ {
+		owner, next, ok := readName(msg, off)
+		if !ok || next+10 > len(msg) {
+			return records
+		}
+		typ := binary.BigEndian.Uint16(msg[next:])
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		data := next + 10
+		if data+length > len(msg) {
+			return records
+		}
+		if typ == 12 {
+			if target, _, ok := readName(msg, data); ok {
+				records[strings.ToLower(owner)] = strings.ToLower(target)
+			}
+		}
+		off = data + length
+	}
+	return records
+}
+
+// readName decodes the possibly compressed name at off and returns it along
+// with the offset just past it.
+func readName(msg []byte, off int) (string, int, bool) {
+	var labels []string
+	end := -1
+	for jumps := 0; jumps < 16; {
+		if off >= len(msg) {
+			return "", 0, false
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, true
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, false
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = (n&0x3F)<<8 | int(msg[off+1])
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, false
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+	return "", 0, false
+}
@@ -0,0 +1,65 @@
+package discover
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// hasNDP tells whether neighbors includes IPv6 entries.
+const hasNDP = true
+
+// Neighbor states worth reporting: the entry holds an address that answered
+// recently, or at some point and is being rechecked, or was set by hand.
+const usableStates = unix.NUD_REACHABLE | unix.NUD_STALE | unix.NUD_DELAY | unix.NUD_PROBE | unix.NUD_PERMANENT
+
+// neighbors dumps the kernel's IPv4 (ARP) and IPv6 (NDP) neighbor caches over
+// netlink, skipping entries that are still being resolved or failed to.
+func neighbors() ([]neighbor, error) {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETNEIGH, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []neighbor
+	for _, m := range msgs {
+		// struct ndmsg: family, 3 bytes padding, ifindex, state, flags, type
+		if m.Header.Type != syscall.RTM_NEWNEIGH || len(m.Data) < unix.SizeofNdMsg {
+			continue
+		}
+		state := binary.NativeEndian.Uint16(m.Data[8:])
+		if state&usableStates == 0 {
+			continue
+		}
+		var n neighbor
+		for attrs := m.Data[unix.SizeofNdMsg:]; len(attrs) >= unix.SizeofRtAttr; {
+			length := int(binary.NativeEndian.Uint16(attrs))
+			if length < unix.SizeofRtAttr || length > len(attrs) {
+				break
+			}
+			value := attrs[unix.SizeofRtAttr:length]
+			switch binary.NativeEndian.Uint16(attrs[2:]) {
+			case unix.NDA_DST:
+				n.ip = net.IP(append([]byte(nil), value...))
+			case unix.NDA_LLADDR:
+				n.mac = net.HardwareAddr(append([]byte(nil), value...))
+			}
+			// Attributes are padded to 4 bytes
+			next := (length + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+			if next > len(attrs) {
+				break
+			}
+			attrs = attrs[next:]
+		}
+		if n.ip != nil && usable(n.mac) {
+			list = append(list, n)
+		}
+	}
+	return list, nil
+}
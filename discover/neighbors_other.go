@@ -0,0 +1,53 @@
+//go:build !linux
+
+package discover
+
+import (
+	"net"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// hasNDP tells whether neighbors includes IPv6 entries. "arp -a" only lists
+// IPv4 ones.
+const hasNDP = false
+
+// arpEntry finds the IP and MAC in a line of "arp -a" output, which looks
+// like "? (192.168.1.1) at 0:11:32:a:b:c on en0 ..." on macOS and the BSDs
+// and "  192.168.1.1    00-11-32-0a-0b-0c    dynamic" on Windows.
+var arpEntry = regexp.MustCompile(`(\d+\.\d+\.\d+\.\d+)\)?\s+(?:at\s+)?([0-9A-Fa-f]{1,2}(?:[:-][0-9A-Fa-f]{1,2}){5})\b`)
+
+// neighbors reads the IPv4 ARP cache from the output of "arp -a".
+func neighbors() ([]neighbor, error) {
+	out, err := exec.Command("arp", "-a").Output()
+	if err != nil {
+		return nil, err
+	}
+	var list []neighbor
+	for _, line := range strings.Split(string(out), "\n") {
+		m := arpEntry.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		ip := net.ParseIP(m[1])
+		mac, err := parseMAC(m[2])
+		if ip == nil || err != nil || !usable(mac) {
+			continue
+		}
+		list = append(list, neighbor{ip: ip, mac: mac})
+	}
+	return list, nil
+}
+
+// parseMAC parses a MAC whose bytes may lack their leading zero, as macOS
+// prints them.
+func parseMAC(s string) (net.HardwareAddr, error) {
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == ':' || r == '-' })
+	for i, p := range parts {
+		if len(p) == 1 {
+			parts[i] = "0" + p
+		}
+	}
+	return net.ParseMAC(strings.Join(parts, ":"))
+}
@@ -0,0 +1,146 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"wol/oui"
+)
+
+var (
+	// ErrOffSubnet means an address isn't on a network attached to this
+	// machine, so its MAC never reaches the neighbor cache.
+	ErrOffSubnet = errors.New("not on a network attached to this machine")
+
+	// ErrNoReply means an address got no ARP or NDP reply, usually because
+	// the host is off.
+	ErrNoReply = errors.New("no reply")
+)
+
+// resolveWait bounds how long Resolve waits for a neighbor reply.
+const resolveWait = 3 * time.Second
+
+// Resolve finds the MAC of the host at an IP address or host name. The host
+// must be on an attached network and awake; a host name that resolves to
+// several addresses is tried IPv4 first.
+func Resolve(ctx context.Context, host string) (Host, error) {
+	ips, err := lookupHost(ctx, host)
+	if err != nil {
+		return Host{}, err
+	}
+
+	err = fmt.Errorf("%s is %w, MACs of hosts behind a router can't be resolved", host, ErrOffSubnet)
+	for _, ip := range ips {
+		if !onLink(ip) {
+			continue
+		}
+		if ip.To4() == nil && !hasNDP {
+			err = fmt.Errorf("%s: IPv6 neighbors can only be looked up on Linux", ip)
+			continue
+		}
+		mac, resolveErr := resolveIP(ctx, ip)
+		if resolveErr != nil {
+			err = resolveErr
+			continue
+		}
+		h := Host{IP: ip.String(), MAC: mac.String(), Vendor: oui.Lookup(mac.String())}
+		if net.ParseIP(host) == nil {
+			h.Hostname = host
+		}
+		return h, nil
+	}
+	return Host{}, err
+}
+
+// lookupHost returns the addresses of host, IPv4 first.
+func lookupHost(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+	sort.SliceStable(ips, func(i, j int) bool { return ips[i].To4() != nil && ips[j].To4() == nil })
+	return ips, nil
+}
+
+// onLink reports whether ip is on the network of a local interface.
+func onLink(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if local, ok := addr.(*net.IPNet); ok && !local.IP.IsLoopback() && local.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveIP returns the MAC of an on-link address: the interface's own for
+// one of this machine's addresses, or else what the neighbor cache holds
+// after a probe has made the kernel ask for it.
+func resolveIP(ctx context.Context, ip net.IP) (net.HardwareAddr, error) {
+	if mac := ownMAC(ip); mac != nil {
+		return mac, nil
+	}
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	conn.WriteToUDP(nil, &net.UDPAddr{IP: ip, Port: 9})
+	conn.Close()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(resolveWait)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline:
+			return nil, fmt.Errorf("%s: %w, is the host on?", ip, ErrNoReply)
+		case <-ticker.C:
+		}
+		list, err := neighbors()
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range list {
+			if n.ip.Equal(ip) {
+				return n.mac, nil
+			}
+		}
+	}
+}
+
+// ownMAC returns the MAC of the local interface that has ip, if any.
+func ownMAC(ip net.IP) net.HardwareAddr {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if local, ok := addr.(*net.IPNet); ok && local.IP.Equal(ip) && usable(iface.HardwareAddr) {
+				return iface.HardwareAddr
+			}
+		}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ go 1.25.4
 require (
 	github.com/getlantern/systray v1.2.2
 	github.com/skratchdot/open-golang v0.0.0-20200116055534-eef842397966
+	go.etcd.io/bbolt v1.4.3
 	golang.org/x/sys v0.38.0
 )
 
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"wol/devicefile"
+	"wol/logger"
+	"wol/storage"
+)
+
+// leaseSyncCheck is how often the lease_sync sources are checked for being
+// due.
+const leaseSyncCheck = time.Minute
+
+// handleSync refreshes device IPs by MAC from a DHCP lease or reservation
+// file given in the request body. ?format= selects the format (default
+// dnsmasq-leases); with ?dry_run=1 only the changes are returned.
+func handleSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = devicefile.DnsmasqLeases
+	}
+	leases, err := devicefile.Read(format, http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dry_run") == "1" || r.URL.Query().Get("dry_run") == "true"
+	changes, err := store.RefreshIPs(actorOf(r), leases, dryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !dryRun {
+		logIPChanges(changes, "uploaded file")
+	}
+	json.NewEncoder(w).Encode(changes)
+}
+
+// syncLeaseFile refreshes device IPs from one lease_sync source.
+func syncLeaseFile(src storage.LeaseSource) ([]storage.IPChange, error) {
+	format := src.Format
+	if format == "" {
+		if format = devicefile.FormatFromName(src.File); format == "" {
+			return nil, fmt.Errorf("can't tell the format of %s, set \"format\"", src.File)
+		}
+	}
+	f, err := os.Open(src.File)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	leases, err := devicefile.Read(format, f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", src.File, err)
+	}
+	return store.RefreshIPs(leaseSyncActor, leases, false)
+}
+
+// startLeaseSync refreshes device IPs from the lease_sync sources, each at
+// its own interval, until shutdown. Sources are re-read from the config on
+// every check, so edits apply without a restart.
+func startLeaseSync() {
+	jobs.Go(func(context.Context) {
+		next := make(map[string]time.Time)
+		lastErr := make(map[string]string)
+		ticker := time.NewTicker(leaseSyncCheck)
+		defer ticker.Stop()
+		for {
+			for _, src := range store.GetLeaseSync() {
+				if time.Now().Before(next[src.File]) {
+					continue
+				}
+				interval := src.IntervalMinutes
+				if interval <= 0 {
+					interval = 5
+				}
+				next[src.File] = time.Now().Add(time.Duration(interval) * time.Minute)
+
+				changes, err := syncLeaseFile(src)
+				if err != nil {
+					// A missing file would otherwise be reported every interval
+					if err.Error() != lastErr[src.File] {
+						logger.Error("System", fmt.Sprintf("Lease sync from %s failed: %v", src.File, err))
+					}
+					lastErr[src.File] = err.Error()
+					continue
+				}
+				delete(lastErr, src.File)
+				logIPChanges(changes, src.File)
+			}
+
+			select {
+			case <-background.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// logIPChanges records each refreshed IP against its device.
+func logIPChanges(changes []storage.IPChange, source string) {
+	for _, c := range changes {
+		old := c.OldIP
+		if old == "" {
+			old = "none"
+		}
+		logger.DeviceInfo(c.DeviceID, c.Device, fmt.Sprintf("IP of %s updated from %s: %s -> %s", c.MAC, source, old, c.NewIP))
+	}
+}
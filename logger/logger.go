@@ -16,6 +16,7 @@ type LogEntry struct {
 	Timestamp string `json:"timestamp"`
 	Level     string `json:"level"`
 	Device    string `json:"device,omitempty"`
+	DeviceID  string `json:"device_id,omitempty"`
 	Message   string `json:"message"`
 }
 
@@ -23,6 +24,8 @@ type Logger struct {
 	mu            sync.Mutex
 	logDir        string
 	retentionDays int
+	closed        bool
+	stop          chan struct{}
 }
 
 var instance *Logger
@@ -34,33 +37,88 @@ func Init(logDir string, retentionDays int) error {
 	instance = &Logger{
 		logDir:        logDir,
 		retentionDays: retentionDays,
+		stop:          make(chan struct{}),
 	}
 	go instance.cleanupRoutine()
 	return nil
 }
 
+// Configure changes the directory and retention of the running logger. New
+// entries go to logDir; files already written stay where they are.
+func Configure(logDir string, retentionDays int) error {
+	if instance == nil {
+		return Init(logDir, retentionDays)
+	}
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return err
+	}
+	instance.mu.Lock()
+	instance.logDir = logDir
+	instance.retentionDays = retentionDays
+	instance.mu.Unlock()
+	go instance.cleanup()
+	return nil
+}
+
+// Close stops the cleanup routine and waits for any in-progress write to
+// finish. Entries logged after Close are discarded.
+func Close() {
+	if instance == nil {
+		return
+	}
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+	if instance.closed {
+		return
+	}
+	instance.closed = true
+	close(instance.stop)
+}
+
 func Info(device, message string) {
 	if instance == nil {
 		return
 	}
-	instance.write("INFO", device, message)
+	instance.write("INFO", "", device, message)
 }
 
 func Error(device, message string) {
 	if instance == nil {
 		return
 	}
-	instance.write("ERROR", device, message)
+	instance.write("ERROR", "", device, message)
 }
 
-func (l *Logger) write(level, device, message string) {
+// DeviceInfo logs a message about a stored device. Entries carry the device
+// ID so they can still be found after the device is renamed.
+func DeviceInfo(id, device, message string) {
+	if instance == nil {
+		return
+	}
+	instance.write("INFO", id, device, message)
+}
+
+// DeviceError is DeviceInfo for errors.
+func DeviceError(id, device, message string) {
+	if instance == nil {
+		return
+	}
+	instance.write("ERROR", id, device, message)
+}
+
+func (l *Logger) write(level, deviceID, device, message string) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	if l.closed {
+		return
+	}
+
 	entry := LogEntry{
 		Timestamp: time.Now().Format("2006-01-02 15:04:05"),
 		Level:     level,
 		Device:    device,
+		DeviceID:  deviceID,
 		Message:   message,
 	}
 
@@ -80,23 +138,37 @@ func (l *Logger) write(level, device, message string) {
 
 	if _, err := f.Write(append(data, '\n')); err != nil {
 		fmt.Println("Error writing to log file:", err)
+		return
+	}
+	if err := f.Sync(); err != nil {
+		fmt.Println("Error syncing log file:", err)
 	}
 }
 
 func (l *Logger) cleanupRoutine() {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
 	for {
 		l.cleanup()
-		time.Sleep(24 * time.Hour)
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
 	}
 }
 
 func (l *Logger) cleanup() {
-	files, err := os.ReadDir(l.logDir)
+	l.mu.Lock()
+	logDir, retentionDays := l.logDir, l.retentionDays
+	l.mu.Unlock()
+
+	files, err := os.ReadDir(logDir)
 	if err != nil {
 		return
 	}
 
-	cutoff := time.Now().AddDate(0, 0, -l.retentionDays)
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
 	
 	for _, file := range files {
 		if file.IsDir() {
@@ -116,12 +188,12 @@ func (l *Logger) cleanup() {
 		}
 
 		if date.Before(cutoff) {
-			os.Remove(filepath.Join(l.logDir, name))
+			os.Remove(filepath.Join(logDir, name))
 		}
 	}
 }
 
-// GetLogs returns logs, optionally filtered by device.
+// GetLogs returns logs, optionally filtered by device ID or name.
 // It reads from the most recent log files up to a certain limit.
 func GetLogs(deviceFilter string, limit int) ([]LogEntry, error) {
 	if instance == nil {
@@ -165,7 +237,7 @@ func GetLogs(deviceFilter string, limit int) ([]LogEntry, error) {
 		for scanner.Scan() {
 			var entry LogEntry
 			if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
-				if deviceFilter == "" || entry.Device == deviceFilter {
+				if deviceFilter == "" || entry.DeviceID == deviceFilter || entry.Device == deviceFilter {
 					fileLogs = append(fileLogs, entry)
 				}
 			}
@@ -1,17 +1,24 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
-	"net/url"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
+	"wol/client"
 	"wol/logger"
+	"wol/oui"
 	"wol/storage"
 	"wol/wol"
 )
@@ -20,16 +27,28 @@ var (
 	//go:embed static
 	staticFiles embed.FS
 
-	store      *storage.Store
-	daemonMode = flag.Bool("d", false, "Run in background (daemon mode)")
-	killMode   = flag.Bool("k", false, "Kill the running daemon (Linux only)")
+	store       *storage.Store
+	server      *http.Server
+	daemonMode  = flag.Bool("d", false, "Run in background (daemon mode)")
+	killMode    = flag.Bool("k", false, "Kill the running daemon (Linux only)")
+	statusMode  = flag.Bool("status", false, "Report whether the daemon is running (Linux only)")
+	restartMode = flag.Bool("restart", false, "Restart the daemon (Linux only)")
+	installMode = flag.Bool("install", false, "Install and enable a systemd unit (Linux only)")
+	removeMode  = flag.Bool("uninstall", false, "Disable and remove the systemd unit (Linux only)")
+	userUnit    = flag.Bool("user", false, "Use a systemd user unit with -install/-uninstall")
+	configFile  = flag.String("config", "wol.json", "Config file; a .db file selects the embedded database")
 )
 
 func main() {
+	if len(os.Args) > 1 && isCommand(os.Args[1]) {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
+	flag.Usage = func() { printUsage(flag.CommandLine.Output()) }
 	flag.Parse()
 
 	var err error
-	store, err = storage.NewStore("wol.json")
+	store, err = storage.NewStore(*configFile)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
@@ -37,6 +56,16 @@ func main() {
 	if err := logger.Init(store.LogDir, store.LogRetentionDays); err != nil {
 		log.Fatalf("Failed to initialize logger: %v", err)
 	}
+	if backup := store.RecoveredFrom(); backup != "" {
+		msg := fmt.Sprintf("%s was corrupt, restored from backup %s", *configFile, backup)
+		fmt.Println(msg)
+		logger.Error("System", msg)
+	}
+	if from := store.ImportedFrom(); from != "" {
+		msg := fmt.Sprintf("Imported %s into %s, the old file was renamed to %s.imported", from, *configFile, from)
+		fmt.Println(msg)
+		logger.Info("System", msg)
+	}
 
 	// Setup HTTP handlers
 	staticFS, err := fs.Sub(staticFiles, "static")
@@ -44,32 +73,148 @@ func main() {
 		log.Fatalf("Failed to create static file system: %v", err)
 	}
 	http.Handle("/", http.FileServer(http.FS(staticFS)))
-	http.HandleFunc("/api/devices", handleDevices)
-	http.HandleFunc("/api/devices/reorder", handleDeviceReorder)
-	http.HandleFunc("/api/devices/", handleDeviceAction) // For update/delete
-	http.HandleFunc("/api/wake/", handleWake)
-	http.HandleFunc("/api/ping/", handlePing)
-	http.HandleFunc("/api/logs", handleLogs)
+
+	api := http.NewServeMux()
+	api.HandleFunc("/api/devices", handleDevices)
+	api.HandleFunc("/api/devices/reorder", handleDeviceReorder)
+	api.HandleFunc("/api/devices/", handleDeviceAction) // For update/delete
+	api.HandleFunc("/api/wake", rateLimited("wake", wakeRateLimit, handleWakeTagged))
+	api.HandleFunc("/api/wake/", rateLimited("wake", wakeRateLimit, handleWake))
+	api.HandleFunc("/api/wake-mac", rateLimited("wake", wakeRateLimit, handleWakeMAC))
+	api.HandleFunc("/api/ping/", rateLimited("ping", pingRateLimit, handlePing))
+	api.HandleFunc("/api/logs", handleLogs)
+	api.HandleFunc("/api/events", handleEvents)
+	api.HandleFunc("/api/import", handleImport)
+	api.HandleFunc("/api/export", handleExport)
+	api.HandleFunc("/api/sync", handleSync)
+	api.HandleFunc("/api/discover", handleDiscover)
+	api.HandleFunc("/api/discover/subnets", handleDiscoverSubnets)
+	api.HandleFunc("/api/resolve", handleResolve)
+	api.HandleFunc("/api/networks", handleNetworks)
+	api.HandleFunc("/api/networks/", handleNetworkAction)
+	api.HandleFunc("/api/settings", handleSettings)
+	api.HandleFunc("/api/audit", handleAudit)
+	api.HandleFunc("/api/audit/", handleAuditAction)
+	api.HandleFunc("/api/trash", handleTrash)
+	api.HandleFunc("/api/trash/", handleTrashAction)
+	http.Handle("/api/", requireToken(api))
+
+	listenPort = store.GetPort()
+	server = &http.Server{Addr: fmt.Sprintf(":%d", listenPort)}
 
 	// Delegate to platform specific run logic
 	runPlatformSpecific()
 }
 
+// startServer serves until shutdown is called, then waits for it to finish.
+// When started by systemd socket activation, the passed socket is used
+// instead of the configured port.
 func startServer() {
-	port := store.GetPort()
-	addr := fmt.Sprintf(":%d", port)
-	fmt.Printf("Server started at http://localhost:%d\n", port)
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	ln, err := systemdListener()
+	if err == nil && ln == nil {
+		ln, err = net.Listen("tcp", server.Addr)
+	}
+	if err != nil {
 		logger.Error("System", fmt.Sprintf("Server failed: %v", err))
 		log.Fatal(err)
 	}
+
+	fmt.Printf("Server started at http://%s\n", displayAddr(ln.Addr()))
+	watchConfig()
+	startLeaseSync()
+	startTrashExpiry()
+	sdNotify("READY=1")
+	startWatchdog(ln.Addr())
+
+	if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("System", fmt.Sprintf("Server failed: %v", err))
+		log.Fatal(err)
+	}
+	<-shutdownDone
+}
+
+// displayAddr turns a wildcard listen address into one usable in a browser.
+func displayAddr(addr net.Addr) string {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok || !tcp.IP.IsUnspecified() {
+		return addr.String()
+	}
+	return fmt.Sprintf("localhost:%d", tcp.Port)
+}
+
+// deviceView is a device as the API returns it, with what each member's MAC
+// tells about it. Groups that reference other devices also list every
+// machine they cover, in the order of the details of their ping status.
+type deviceView struct {
+	storage.Device
+	SubDevices    []memberView `json:"sub_devices,omitempty"`
+	AllMembers    []memberView `json:"all_members,omitempty"`
+	InMaintenance bool         `json:"in_maintenance,omitempty"` // Maintenance is set and hasn't ended
+}
+
+type memberView struct {
+	storage.SubDevice
+	Vendor   string          `json:"vendor,omitempty"`    // From the bundled OUI table
+	LocalMAC bool            `json:"local_mac,omitempty"` // Locally administered, e.g. randomized; won't wake
+	From     string          `json:"from,omitempty"`      // Referenced device the member belongs to
+	Target   *storage.Target `json:"target,omitempty"`    // Where packets go, for members on a network
+}
+
+func viewMember(sub storage.SubDevice) memberView {
+	v := memberView{
+		SubDevice: sub,
+		Vendor:    oui.Lookup(sub.MAC),
+		LocalMAC:  oui.LocallyAdministered(sub.MAC),
+	}
+	if sub.Network != "" {
+		target := store.Target(sub)
+		v.Target = &target
+	}
+	return v
+}
+
+func viewDevice(d storage.Device) deviceView {
+	v := deviceView{Device: d.WithoutSecrets(), InMaintenance: d.InMaintenance()}
+	for _, sub := range d.SubDevices {
+		v.SubDevices = append(v.SubDevices, viewMember(sub))
+	}
+	if len(d.Members) > 0 {
+		for _, m := range store.Expand(d) {
+			mv := viewMember(m.SubDevice)
+			if m.DeviceID != d.ID {
+				mv.From = m.DeviceName
+			}
+			v.AllMembers = append(v.AllMembers, mv)
+		}
+	}
+	return v
+}
+
+// deviceErrorStatus is the HTTP status for an error adding or updating a
+// device.
+func deviceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrDuplicateMAC):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrUnknownMember), errors.Is(err, storage.ErrMemberCycle), errors.Is(err, storage.ErrNetworkNotFound):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
 }
 
 func handleDevices(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		devices := store.GetAll()
-		json.NewEncoder(w).Encode(devices)
+		devices, err := filterDevices(store.GetAll(), r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		views := make([]deviceView, len(devices))
+		for i, d := range devices {
+			views[i] = viewDevice(d)
+		}
+		json.NewEncoder(w).Encode(views)
 	case http.MethodPost:
 		var d storage.Device
 		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
@@ -80,12 +225,13 @@ func handleDevices(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Name is required", http.StatusBadRequest)
 			return
 		}
-		if err := store.AddDevice(d); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		d, err := store.AddDevice(actorOf(r), d)
+		if err != nil {
+			http.Error(w, err.Error(), deviceErrorStatus(err))
 			return
 		}
-		logger.Info(d.Name, "Device added")
-		json.NewEncoder(w).Encode(d)
+		logger.DeviceInfo(d.ID, d.Name, "Device added")
+		json.NewEncoder(w).Encode(viewDevice(d))
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -97,13 +243,14 @@ func handleDeviceReorder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var names []string
-	if err := json.NewDecoder(r.Body).Decode(&names); err != nil {
+	// IDs, or names for older clients
+	var keys []string
+	if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if err := store.ReorderDevices(names); err != nil {
+	if err := store.ReorderDevices(actorOf(r), keys); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -111,39 +258,78 @@ func handleDeviceReorder(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// deviceKey returns the device ID or name following prefix in the request
+// path. r.URL.Path is already unescaped, so names may contain '/'.
+func deviceKey(r *http.Request, prefix string) string {
+	return strings.TrimPrefix(r.URL.Path, prefix)
+}
+
 func handleDeviceAction(w http.ResponseWriter, r *http.Request) {
-	name := r.URL.Path[len("/api/devices/"):]
-	if name == "" {
-		http.Error(w, "Name required", http.StatusBadRequest)
+	key := deviceKey(r, "/api/devices/")
+	if key, ok := strings.CutSuffix(key, "/maintenance"); ok && key != "" {
+		handleMaintenance(w, r, key)
 		return
 	}
-	
-	decodedName, err := url.QueryUnescape(name)
-	if err != nil {
-		http.Error(w, "Invalid name encoding", http.StatusBadRequest)
+	if key, ok := strings.CutSuffix(key, "/protection"); ok && key != "" {
+		handleProtection(w, r, key)
+		return
+	}
+	if key == "" {
+		http.Error(w, "Device ID required", http.StatusBadRequest)
 		return
 	}
 
 	switch r.Method {
+	case http.MethodGet:
+		device, found := store.GetDevice(key)
+		if !found {
+			http.Error(w, "Device not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(viewDevice(device))
 	case http.MethodPut:
 		var d storage.Device
 		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		// We pass the old name (from URL) and the new device object (from body)
-		if err := store.UpdateDevice(decodedName, d); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		old, found := store.GetDevice(key)
+		if !found {
+			http.Error(w, "Device not found", http.StatusNotFound)
 			return
 		}
-		logger.Info(d.Name, fmt.Sprintf("Device updated (old name: %s)", decodedName))
-		json.NewEncoder(w).Encode(d)
+		d, err := store.UpdateDevice(actorOf(r), key, d)
+		if err != nil {
+			http.Error(w, err.Error(), deviceErrorStatus(err))
+			return
+		}
+		if old.Name != d.Name {
+			logger.DeviceInfo(d.ID, d.Name, fmt.Sprintf("Device updated (old name: %s)", old.Name))
+		} else {
+			logger.DeviceInfo(d.ID, d.Name, "Device updated")
+		}
+		json.NewEncoder(w).Encode(viewDevice(d))
 	case http.MethodDelete:
-		if err := store.DeleteDevice(decodedName); err != nil {
+		d, err := store.DeleteDevice(actorOf(r), key)
+		switch {
+		case errors.Is(err, storage.ErrDeviceNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, storage.ErrInUse):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		logger.Info(decodedName, "Device deleted")
+		purge := purgeRequested(r)
+		if purge {
+			if _, err := store.PurgeDevice(actorOf(r), d.ID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		logDelete(d, purge)
 		w.WriteHeader(http.StatusOK)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -151,102 +337,162 @@ func handleDeviceAction(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleWake(w http.ResponseWriter, r *http.Request) {
-	name := r.URL.Path[len("/api/wake/"):]
-	decodedName, err := url.QueryUnescape(name)
+	device, found := store.GetDevice(deviceKey(r, "/api/wake/"))
+	if !found {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+	proof, err := decodeWakeProof(r)
 	if err != nil {
-		http.Error(w, "Invalid name encoding", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from := describeActor(actorOf(r))
+	if err := authorizeWake(device, proof, from); err != nil {
+		http.Error(w, err.Error(), wakeErrorStatus(w, err))
+		return
+	}
+	cancelCooldown, err := checkCooldown(device, store.GetSettings(), from)
+	if err != nil {
+		http.Error(w, err.Error(), wakeErrorStatus(w, err))
 		return
 	}
 
-	device, found := store.GetDevice(decodedName)
-	if !found {
-		http.Error(w, "Device not found", http.StatusNotFound)
+	ctx, done, ok := jobs.Start()
+	if !ok {
+		cancelCooldown()
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
 		return
 	}
+	defer done()
 
-	targetPort := device.Port
-	if targetPort == 0 {
-		targetPort = 9
+	msg, sent, err := wakeDevice(ctx, device, store.Expand(device))
+	if !sent {
+		cancelCooldown()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), wakeErrorStatus(w, err))
+		return
 	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(msg))
+}
+
+// wakeDevice sends magic packets to a device or every member of a group,
+// including those of the groups it references, and logs the outcome. members
+// is the device expanded by storage.Expand. For groups, failures of
+// individual members are logged but only reported in the summary message.
+// A device in maintenance fails with storage.ErrMaintenance, and members of
+// referenced devices in maintenance or protected (see authorizeWake) are
+// skipped. sent reports whether any packets went out.
+func wakeDevice(ctx context.Context, device storage.Device, members []storage.Member) (msg string, sent bool, err error) {
+	now := time.Now()
+	if device.Maintenance.Active(now) {
+		err := device.Maintenance.Err()
+		logger.DeviceError(device.ID, device.Name, "Wake blocked: "+err.Error())
+		return "", false, err
+	}
+
+	if len(members) > 0 {
+		logger.DeviceInfo(device.ID, device.Name, fmt.Sprintf("Sending WOL packets to group (%d devices)...", len(members)))
 
-	// Log the attempt
-	if len(device.SubDevices) > 0 {
-		logger.Info(device.Name, fmt.Sprintf("Sending WOL packets to group (%d devices)...", len(device.SubDevices)))
-		
 		var errs []string
-		for i, sub := range device.SubDevices {
-			targetPort := sub.Port
-			if targetPort == 0 {
-				targetPort = 9
+		skipped := 0
+		for i, sub := range members {
+			if sub.Maintenance.Active(now) {
+				logger.DeviceInfo(device.ID, device.Name, fmt.Sprintf("Device %d (%s): skipped, %v", i+1, memberLabel(device, sub), sub.Maintenance.Err()))
+				skipped++
+				continue
+			}
+			if sub.Protected && sub.DeviceID != device.ID {
+				logger.DeviceInfo(device.ID, device.Name, fmt.Sprintf("Device %d (%s): skipped, %s is protected and must be woken on its own", i+1, memberLabel(device, sub), sub.DeviceName))
+				skipped++
+				continue
 			}
-			targetDesc := sub.BroadcastIP
-			if targetDesc == "" {
-				targetDesc = "all interfaces"
+			if oui.LocallyAdministered(sub.MAC) {
+				logger.DeviceInfo(device.ID, device.Name, fmt.Sprintf("Device %d (%s): locally administered MAC, the card may not wake for it", i+1, memberLabel(device, sub)))
 			}
-			
-			if err := wol.Wake(sub.MAC, sub.BroadcastIP, targetPort); err != nil {
-				errMsg := fmt.Sprintf("Device %d (%s): %v", i+1, sub.MAC, err)
+
+			opts := wol.Options{BroadcastIP: sub.Target.Address, Port: sub.Target.Port, Interface: sub.Target.Interface}
+			if err := wol.WakeWith(ctx, sub.MAC, opts); err != nil {
+				errMsg := fmt.Sprintf("Device %d (%s): %v", i+1, memberLabel(device, sub), err)
 				errs = append(errs, errMsg)
-				logger.Error(device.Name, errMsg)
+				logger.DeviceError(device.ID, device.Name, errMsg)
 			}
 		}
-		
+		sent := len(errs)+skipped < len(members)
+
 		if len(errs) > 0 {
-			// If some failed, we still consider it a partial success or just log errors
-			// For HTTP response, if all failed, maybe error?
-			// Let's just return OK but log errors.
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(fmt.Sprintf("Group wake completed with %d errors", len(errs))))
-			return
+			return fmt.Sprintf("Group wake completed with %d errors", len(errs)), sent, nil
 		}
-		
-		logger.Info(device.Name, "Group wake completed successfully")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Group wake completed"))
-		return
+		if skipped > 0 {
+			msg := fmt.Sprintf("Group wake completed, %d skipped", skipped)
+			logger.DeviceInfo(device.ID, device.Name, msg)
+			return msg, sent, nil
+		}
+
+		logger.DeviceInfo(device.ID, device.Name, "Group wake completed successfully")
+		return "Group wake completed", true, nil
 	}
 
+	targetPort := device.Port
+	if targetPort == 0 {
+		targetPort = 9
+	}
 	targetDesc := device.BroadcastIP
 	if targetDesc == "" {
 		targetDesc = "all interfaces"
 	}
-	logger.Info(device.Name, fmt.Sprintf("Sending WOL packets to %s:%d...", targetDesc, targetPort))
+	logger.DeviceInfo(device.ID, device.Name, fmt.Sprintf("Sending WOL packets to %s:%d...", targetDesc, targetPort))
 
 	// Wake function now handles repeated sending internally (5 times, 100ms interval)
 	// If BroadcastIP is empty, it iterates over all IPv4 interfaces.
-	if err := wol.Wake(device.MAC, device.BroadcastIP, targetPort); err != nil {
+	if err := wol.WakeContext(ctx, device.MAC, device.BroadcastIP, targetPort); err != nil {
 		errMsg := fmt.Sprintf("Failed to send WOL packet: %v", err)
-		logger.Error(device.Name, errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
-		return
+		logger.DeviceError(device.ID, device.Name, errMsg)
+		return "", false, errors.New(errMsg)
 	}
 
 	successMsg := fmt.Sprintf("Magic packets sent to %s:%d", targetDesc, targetPort)
-	logger.Info(device.Name, successMsg)
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(successMsg))
+	logger.DeviceInfo(device.ID, device.Name, successMsg)
+	return successMsg, true, nil
 }
 
-func handlePing(w http.ResponseWriter, r *http.Request) {
-	name := r.URL.Path[len("/api/ping/"):]
-	decodedName, err := url.QueryUnescape(name)
-	if err != nil {
-		http.Error(w, "Invalid name encoding", http.StatusBadRequest)
-		return
+// memberLabel names a member in log messages: its MAC, and the device it
+// comes from if that is a referenced group member.
+func memberLabel(device storage.Device, m storage.Member) string {
+	if m.DeviceID != device.ID {
+		return fmt.Sprintf("%s of %s", m.MAC, m.DeviceName)
 	}
+	return m.MAC
+}
 
-	device, found := store.GetDevice(decodedName)
+func handlePing(w http.ResponseWriter, r *http.Request) {
+	device, found := store.GetDevice(deviceKey(r, "/api/ping/"))
 	if !found {
 		http.Error(w, "Device not found", http.StatusNotFound)
 		return
 	}
 
-	if len(device.SubDevices) > 0 {
-		total := len(device.SubDevices)
+	status, err := checkDevice(device, store.Expand(device))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// checkDevice pings a device, or every member of a group concurrently, and
+// applies the group's ping mode to decide whether it counts as online.
+// members is the device expanded by storage.Expand, so referenced groups
+// count with all of their members.
+func checkDevice(device storage.Device, members []storage.Member) (client.Status, error) {
+	if len(members) > 0 {
+		total := len(members)
 		details := make([]bool, total)
 		var wg sync.WaitGroup
 
-		for i, sub := range device.SubDevices {
+		for i, sub := range members {
 			wg.Add(1)
 			go func(i int, ip string) {
 				defer wg.Done()
@@ -272,19 +518,18 @@ func handlePing(w http.ResponseWriter, r *http.Request) {
 			overallOnline = (onlineCount > 0)
 		}
 
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"online":       overallOnline,
-			"total":        total,
-			"online_count": onlineCount,
-			"details":      details,
-			"mode":         device.PingMode,
-		})
-		return
+		return client.Status{
+			Online:      overallOnline,
+			Total:       total,
+			OnlineCount: onlineCount,
+			Details:     details,
+			Mode:        device.PingMode,
+			Maintenance: device.InMaintenance(),
+		}, nil
 	}
 
 	if device.IP == "" {
-		http.Error(w, "Device has no IP address", http.StatusBadRequest)
-		return
+		return client.Status{}, errors.New("Device has no IP address")
 	}
 
 	online := ping(device.IP)
@@ -292,12 +537,13 @@ func handlePing(w http.ResponseWriter, r *http.Request) {
 	if online {
 		onlineCount = 1
 	}
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"online":       online,
-		"total":        1,
-		"online_count": onlineCount,
-		"details":      []bool{online},
-	})
+	return client.Status{
+		Online:      online,
+		Total:       1,
+		OnlineCount: onlineCount,
+		Details:     []bool{online},
+		Maintenance: device.InMaintenance(),
+	}, nil
 }
 
 func handleLogs(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"wol/logger"
+	"wol/storage"
+)
+
+// handleMaintenance serves PUT /api/devices/{id}/maintenance, which takes
+// {"reason": ..., "until": RFC 3339 time or absent for no end}, and DELETE to
+// end the maintenance.
+func handleMaintenance(w http.ResponseWriter, r *http.Request, key string) {
+	var m *storage.Maintenance
+	switch r.Method {
+	case http.MethodPut:
+		m = &storage.Maintenance{}
+		if err := json.NewDecoder(r.Body).Decode(m); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, found := store.GetDevice(key); !found {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+	d, err := store.SetMaintenance(actorOf(r), key, m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logMaintenance(d)
+	json.NewEncoder(w).Encode(viewDevice(d))
+}
+
+// logMaintenance logs that d was put in or taken out of maintenance.
+func logMaintenance(d storage.Device) {
+	if d.Maintenance == nil {
+		logger.DeviceInfo(d.ID, d.Name, "Maintenance ended")
+		return
+	}
+	logger.DeviceInfo(d.ID, d.Name, strings.TrimSpace("Maintenance started, wakes are blocked "+d.Maintenance.String()))
+}
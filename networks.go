@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"wol/logger"
+	"wol/storage"
+)
+
+// networkErrorStatus is the HTTP status for an error changing a network.
+func networkErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNetworkNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrInUse):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+func handleNetworks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		networks := store.GetNetworks()
+		if networks == nil {
+			networks = []storage.Network{}
+		}
+		json.NewEncoder(w).Encode(networks)
+	case http.MethodPost:
+		var n storage.Network
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n, err := store.AddNetwork(actorOf(r), n)
+		if err != nil {
+			http.Error(w, err.Error(), networkErrorStatus(err))
+			return
+		}
+		logger.Info("System", fmt.Sprintf("Network %s added", n.Name))
+		json.NewEncoder(w).Encode(n)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleNetworkAction(w http.ResponseWriter, r *http.Request) {
+	name := deviceKey(r, "/api/networks/")
+	if name == "" {
+		http.Error(w, "Network name required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		n, found := store.GetNetwork(name)
+		if !found {
+			http.Error(w, "Network not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(n)
+	case http.MethodPut:
+		var n storage.Network
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n, err := store.UpdateNetwork(actorOf(r), name, n)
+		if err != nil {
+			http.Error(w, err.Error(), networkErrorStatus(err))
+			return
+		}
+		if n.Name != name {
+			logger.Info("System", fmt.Sprintf("Network %s updated (old name: %s)", n.Name, name))
+		} else {
+			logger.Info("System", fmt.Sprintf("Network %s updated", n.Name))
+		}
+		json.NewEncoder(w).Encode(n)
+	case http.MethodDelete:
+		n, err := store.DeleteNetwork(actorOf(r), name)
+		if err != nil {
+			http.Error(w, err.Error(), networkErrorStatus(err))
+			return
+		}
+		logger.Info("System", fmt.Sprintf("Network %s deleted", n.Name))
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,73 @@
+//go:build ignore
+
+// Gen rebuilds oui.txt from the IEEE MA-L registry. Run it with
+// "go generate ./oui".
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+const registry = "https://standards-oui.ieee.org/oui/oui.csv"
+
+func main() {
+	resp, err := http.Get(registry)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("%s: %s", registry, resp.Status)
+	}
+
+	// Registry,Assignment,Organization Name,Organization Address
+	cr := csv.NewReader(resp.Body)
+	cr.FieldsPerRecord = -1
+	vendors := make(map[string]string)
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(rec) < 3 || rec[0] != "MA-L" {
+			continue
+		}
+		vendors[strings.ToUpper(rec[1])] = strings.Join(strings.Fields(rec[2]), " ")
+	}
+	if len(vendors) == 0 {
+		log.Fatal("no assignments found")
+	}
+
+	prefixes := make([]string, 0, len(vendors))
+	for p := range vendors {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+
+	f, err := os.Create("oui.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "# OUI vendor table: hex prefix, tab, organization. Generated from\n# %s by gen.go.\n", registry)
+	for _, p := range prefixes {
+		fmt.Fprintf(w, "%s\t%s\n", p, vendors[p])
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
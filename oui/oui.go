@@ -0,0 +1,53 @@
+// Package oui looks up the vendor of a network card from the first three
+// bytes of its MAC address, using a table embedded at build time.
+package oui
+
+//go:generate go run gen.go
+
+import (
+	_ "embed"
+	"net"
+	"strings"
+	"sync"
+)
+
+//go:embed oui.txt
+var table string
+
+var (
+	once    sync.Once
+	vendors map[string]string
+)
+
+// load parses the embedded table on first use.
+func load() {
+	vendors = make(map[string]string)
+	for _, line := range strings.Split(table, "\n") {
+		prefix, vendor, ok := strings.Cut(strings.TrimSpace(line), "\t")
+		if !ok || strings.HasPrefix(prefix, "#") {
+			continue
+		}
+		vendors[strings.ToUpper(prefix)] = vendor
+	}
+}
+
+// Lookup returns the vendor registered for mac, or "" if the MAC can't be
+// parsed or its prefix isn't in the table. Locally administered MACs, such as
+// randomized or virtual ones, never have a vendor.
+func Lookup(mac string) string {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) < 3 || hw[0]&0x02 != 0 {
+		return ""
+	}
+	once.Do(load)
+	return vendors[strings.ToUpper(strings.ReplaceAll(hw[:3].String(), ":", ""))]
+}
+
+// LocallyAdministered reports whether mac has the locally administered bit
+// set, as randomized ("private") Wi-Fi addresses do. Such an address is
+// assigned by the operating system, so the card doesn't know it while the
+// machine is off and won't wake for it.
+func LocallyAdministered(mac string) bool {
+	hw, err := net.ParseMAC(mac)
+	return err == nil && len(hw) > 0 && hw[0]&0x02 != 0
+}
@@ -0,0 +1,121 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// pidLock is a PID file holding an exclusive flock for the daemon's lifetime,
+// so a second daemon cannot start while the first is alive.
+type pidLock struct {
+	path string
+	f    *os.File
+}
+
+func lockPIDFile(path string) (*pidLock, error) {
+	var f *os.File
+	for {
+		var err error
+		f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+			f.Close()
+			if errors.Is(err, unix.EWOULDBLOCK) {
+				pid, _ := readPIDFile(path)
+				return nil, fmt.Errorf("daemon already running (PID %d)", pid)
+			}
+			return nil, err
+		}
+		// A stale file removed between opening and locking it leaves us with
+		// a lock nobody else sees, so start over on the new one.
+		if sameFile(f, path) {
+			break
+		}
+		f.Close()
+	}
+
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &pidLock{path: path, f: f}, nil
+}
+
+// Release removes the PID file and drops the lock.
+func (l *pidLock) Release() {
+	os.Remove(l.path)
+	l.f.Close()
+}
+
+// sameFile reports whether f is still the file at path.
+func sameFile(f *os.File, path string) bool {
+	a, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	b, err := os.Stat(path)
+	return err == nil && os.SameFile(a, b)
+}
+
+func readPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid PID file content: %v", err)
+	}
+	return pid, nil
+}
+
+// daemonPID returns the PID of the running daemon. A PID file whose lock is
+// not held and that names a process that is gone was left behind by a daemon
+// that died; it is removed and reported as not running. Any other unlocked
+// file may belong to a daemon that hasn't taken the lock yet, and is kept.
+func daemonPID(path string) (pid int, running bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	defer f.Close()
+
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_SH|unix.LOCK_NB); err != nil {
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			pid, err := readPIDFile(path)
+			return pid, true, err
+		}
+		return 0, false, err
+	}
+	defer unix.Flock(int(f.Fd()), unix.LOCK_UN)
+
+	pid, err = readPIDFile(path)
+	if err != nil || processAlive(pid) || !sameFile(f, path) {
+		return 0, false, nil
+	}
+	fmt.Println("Removing stale PID file", path)
+	os.Remove(path)
+	return 0, false, nil
+}
+
+// processAlive reports whether a process with the given PID exists.
+func processAlive(pid int) bool {
+	err := unix.Kill(pid, 0)
+	return err == nil || errors.Is(err, unix.EPERM)
+}
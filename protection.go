@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"wol/logger"
+	"wol/storage"
+)
+
+const (
+	maxPINFailures = 5                // Wrong PINs in a row before a device is locked
+	pinLockout     = 15 * time.Minute // How long it stays locked
+)
+
+type pinFailures struct {
+	count       int
+	lockedUntil time.Time
+}
+
+// pinGuard counts wrong PINs per device, so a PIN can't be guessed by
+// trying them all.
+type pinGuard struct {
+	mu       sync.Mutex
+	failures map[string]*pinFailures
+}
+
+var pins = &pinGuard{failures: make(map[string]*pinFailures)}
+
+// locked returns how long the device stays locked, or 0.
+func (g *pinGuard) locked(id string, now time.Time) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f := g.failures[id]; f != nil && now.Before(f.lockedUntil) {
+		return f.lockedUntil.Sub(now)
+	}
+	return 0
+}
+
+// fail records a wrong PIN and returns the number in a row, and the lockout
+// if that was one too many.
+func (g *pinGuard) fail(id string, now time.Time) (int, time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f := g.failures[id]
+	if f == nil || !f.lockedUntil.IsZero() {
+		f = &pinFailures{}
+		g.failures[id] = f
+	}
+	f.count++
+	if f.count < maxPINFailures {
+		return f.count, 0
+	}
+	f.lockedUntil = now.Add(pinLockout)
+	return f.count, pinLockout
+}
+
+func (g *pinGuard) reset(id string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.failures, id)
+}
+
+// authorizeWake checks that proof is enough to wake device, see
+// storage.Protection, and logs refusals. from names where the attempt came
+// from for the log.
+func authorizeWake(device storage.Device, proof storage.WakeProof, from string) error {
+	if device.Protection == nil {
+		return nil
+	}
+	now := time.Now()
+	if device.Protection.Level == storage.ProtectPIN {
+		if retry := pins.locked(device.ID, now); retry > 0 {
+			err := &retryError{reason: "too many wrong PINs", retry: retry}
+			logger.DeviceError(device.ID, device.Name, fmt.Sprintf("Wake refused (%s): %v", from, err))
+			return err
+		}
+	}
+
+	err := device.Protection.Check(proof)
+	switch {
+	case err == nil:
+		if proof.PIN != "" {
+			pins.reset(device.ID)
+		}
+	case errors.Is(err, storage.ErrWrongPIN):
+		n, lockout := pins.fail(device.ID, now)
+		msg := fmt.Sprintf("Wake refused (%s): wrong PIN, %d of %d attempts", from, n, maxPINFailures)
+		if lockout > 0 {
+			msg += fmt.Sprintf(", wakes locked for %s", lockout)
+		}
+		logger.DeviceError(device.ID, device.Name, msg)
+	default:
+		logger.DeviceError(device.ID, device.Name, fmt.Sprintf("Wake refused (%s): %v", from, err))
+	}
+	return err
+}
+
+// wakeErrorStatus is the HTTP status for an error waking a device, and sets
+// Retry-After where it applies.
+func wakeErrorStatus(w http.ResponseWriter, err error) int {
+	var retry *retryError
+	switch {
+	case errors.Is(err, storage.ErrMaintenance):
+		return http.StatusLocked
+	case errors.Is(err, storage.ErrConfirmRequired), errors.Is(err, storage.ErrPINRequired):
+		return http.StatusPreconditionRequired
+	case errors.Is(err, storage.ErrWrongPIN):
+		return http.StatusForbidden
+	case errors.As(err, &retry):
+		setRetryAfter(w, retry.retry)
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// decodeWakeProof reads the optional {"confirm": true} or {"pin": ...} body
+// of a wake request.
+func decodeWakeProof(r *http.Request) (storage.WakeProof, error) {
+	var proof storage.WakeProof
+	if err := json.NewDecoder(r.Body).Decode(&proof); err != nil && err != io.EOF {
+		return proof, err
+	}
+	return proof, nil
+}
+
+// handleProtection serves PUT /api/devices/{id}/protection, which takes
+// {"level": "confirm" or "pin", "pin": ...}, and DELETE to remove the
+// protection. The pin can be left out to keep the current one.
+func handleProtection(w http.ResponseWriter, r *http.Request, key string) {
+	var req struct {
+		Level string `json:"level"`
+		PIN   string `json:"pin"`
+	}
+	switch r.Method {
+	case http.MethodPut:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Level == "" {
+			http.Error(w, "level is required", http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, found := store.GetDevice(key); !found {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+	d, err := store.SetProtection(actorOf(r), key, req.Level, req.PIN)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logProtection(d)
+	json.NewEncoder(w).Encode(viewDevice(d))
+}
+
+// logProtection logs the new protection level of d.
+func logProtection(d storage.Device) {
+	switch {
+	case d.Protection == nil:
+		logger.DeviceInfo(d.ID, d.Name, "Wake protection removed")
+	case d.Protection.Level == storage.ProtectPIN:
+		logger.DeviceInfo(d.ID, d.Name, "Wake protection set, a PIN is required")
+	default:
+		logger.DeviceInfo(d.ID, d.Name, "Wake protection set, a confirmation is required")
+	}
+}
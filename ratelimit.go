@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"wol/logger"
+	"wol/storage"
+)
+
+// rateLimitLog is the log name of suppressed requests, so they can be told
+// apart from the outcome of those that went through.
+const rateLimitLog = "RateLimit"
+
+// suppressedLogInterval is how often repeated suppressions of the same
+// requests are logged, as a count.
+const suppressedLogInterval = 10 * time.Second
+
+// retryError is returned for a request refused for now, and becomes 429 Too
+// Many Requests with Retry-After.
+type retryError struct {
+	reason string
+	retry  time.Duration
+}
+
+func (e *retryError) Error() string {
+	return fmt.Sprintf("%s, try again in %s", e.reason, e.retry.Round(time.Second))
+}
+
+// setRetryAfter sets Retry-After to the whole seconds until retry is over.
+func setRetryAfter(w http.ResponseWriter, retry time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int((retry+time.Second-1)/time.Second)))
+}
+
+type suppression struct {
+	count  int
+	logged time.Time
+}
+
+// limiter enforces wake_rate_limit and ping_rate_limit per client, and the
+// wake cooldown per device.
+type limiter struct {
+	mu         sync.Mutex
+	requests   map[string][]time.Time // Per kind and client, within the last minute
+	cooldowns  map[string]time.Time   // Per device in a cooldown, when it may be woken again
+	suppressed map[string]*suppression
+}
+
+var limits = &limiter{
+	requests:   make(map[string][]time.Time),
+	cooldowns:  make(map[string]time.Time),
+	suppressed: make(map[string]*suppression),
+}
+
+// allow counts a request of kind from client and returns how long until it
+// would be allowed if it is over perMinute, or 0 if it goes through.
+func (l *limiter) allow(kind, client string, perMinute int, now time.Time) time.Duration {
+	if perMinute < 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := kind + " " + client
+	times := l.requests[key]
+	for len(times) > 0 && now.Sub(times[0]) >= time.Minute {
+		times = times[1:]
+	}
+	if len(times) >= perMinute {
+		l.requests[key] = times
+		return times[0].Add(time.Minute).Sub(now)
+	}
+	l.requests[key] = append(times, now)
+
+	// Forget idle clients now and then
+	if len(l.requests) > 1000 {
+		for k, t := range l.requests {
+			if len(t) == 0 || now.Sub(t[len(t)-1]) >= time.Minute {
+				delete(l.requests, k)
+			}
+		}
+	}
+	return 0
+}
+
+// startCooldown starts the cooldown of the device with the given ID if it
+// isn't in one, or returns how long it has left. Cooldowns that are over are
+// dropped along the way.
+func (l *limiter) startCooldown(id string, cooldown time.Duration, now time.Time) time.Duration {
+	if cooldown <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for k, until := range l.cooldowns {
+		if !now.Before(until) {
+			delete(l.cooldowns, k)
+		}
+	}
+	if until, ok := l.cooldowns[id]; ok {
+		return until.Sub(now)
+	}
+	l.cooldowns[id] = now.Add(cooldown)
+	return 0
+}
+
+// cancelCooldown ends the cooldown of the device with the given ID that
+// lasts until, unless another one has replaced it.
+func (l *limiter) cancelCooldown(id string, until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cooldowns[id].Equal(until) {
+		delete(l.cooldowns, id)
+	}
+}
+
+// logSuppressed logs a refused request. While the same requests keep coming
+// they are logged once every suppressedLogInterval, with how many there were.
+func (l *limiter) logSuppressed(what, client string, err error, now time.Time) {
+	l.mu.Lock()
+	key := what + " " + client
+	s := l.suppressed[key]
+	if s == nil {
+		s = &suppression{}
+		l.suppressed[key] = s
+	}
+	s.count++
+	if now.Sub(s.logged) < suppressedLogInterval {
+		l.mu.Unlock()
+		return
+	}
+	count := s.count
+	s.count, s.logged = 0, now
+	for k, s := range l.suppressed {
+		if now.Sub(s.logged) >= suppressedLogInterval && s.count == 0 {
+			delete(l.suppressed, k)
+		}
+	}
+	l.mu.Unlock()
+
+	msg := fmt.Sprintf("Suppressed %s (%s): %v", what, client, err)
+	if count > 1 {
+		msg = fmt.Sprintf("Suppressed %s (%s) %d times: %v", what, client, count, err)
+	}
+	logger.Info(rateLimitLog, msg)
+}
+
+// cooldownOf is how long after a wake of d further wakes are refused.
+func cooldownOf(d storage.Device, st storage.Settings) time.Duration {
+	secs := st.WakeCooldown
+	if d.Cooldown != 0 {
+		secs = d.Cooldown
+	}
+	if secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// checkCooldown refuses to wake device if it was woken less than its
+// cooldown ago, see wake_cooldown_seconds in st, and otherwise starts a new
+// one. The returned func lifts it again if the wake sent nothing after all.
+// from names who asked for the log.
+func checkCooldown(device storage.Device, st storage.Settings, from string) (func(), error) {
+	if device.InMaintenance() {
+		// wakeDevice refuses it without sending anything
+		return func() {}, nil
+	}
+	now := time.Now()
+	cooldown := cooldownOf(device, st)
+	retry := limits.startCooldown(device.ID, cooldown, now)
+	if retry == 0 {
+		return func() { limits.cancelCooldown(device.ID, now.Add(cooldown)) }, nil
+	}
+	err := &retryError{reason: device.Name + " was woken moments ago", retry: retry}
+	limits.logSuppressed("wake of "+device.Name, from, err, now)
+	return func() {}, err
+}
+
+func wakeRateLimit(st storage.Settings) int { return st.WakeRateLimit }
+func pingRateLimit(st storage.Settings) int { return st.PingRateLimit }
+
+// rateLimited refuses requests of kind ("wake" or "ping") from a client
+// that made more than the limit returned by perMinute in the last minute.
+func rateLimited(kind string, perMinute func(storage.Settings) int, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		now := time.Now()
+		limit := perMinute(store.GetSettings())
+		if retry := limits.allow(kind, client, limit, now); retry > 0 {
+			err := &retryError{reason: fmt.Sprintf("more than %d %s requests a minute", limit, kind), retry: retry}
+			limits.logSuppressed(r.Method+" "+r.URL.Path, client, err, now)
+			setRetryAfter(w, retry)
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
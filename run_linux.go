@@ -8,74 +8,189 @@ import (
 	"os/exec"
 	"strconv"
 	"syscall"
+	"time"
 )
 
-const pidFile = "wol.pid"
+const (
+	pidFile = "wol.pid"
+	// daemonLogFile receives the daemon's stdout and stderr.
+	daemonLogFile = "wol.out"
+)
 
 func runPlatformSpecific() {
-	if *killMode {
-		killDaemon()
+	switch {
+	case *killMode:
+		if err := stopDaemon(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	case *statusMode:
+		daemonStatus()
+		return
+	case *restartMode:
+		if err := stopDaemon(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := startDaemon(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	case *installMode, *removeMode:
+		if err := setAutoStart(*installMode); err != nil {
+			fmt.Println("Failed to update systemd unit:", err)
+			os.Exit(1)
+		}
+		if *installMode {
+			fmt.Println("systemd unit installed and started.")
+		} else {
+			fmt.Println("systemd unit removed.")
+		}
 		return
 	}
 
 	if *daemonMode {
-		// Check if already running as daemon (simple check to avoid infinite loop if logic is flawed)
-		// But here we just re-execute self and exit.
-		// To prevent infinite loop, we can check an env var or assume the user knows what they are doing.
-		// A better way is to check if PPID is 1 (init), but that's not always true.
-		// Let's use a simple environment variable marker.
+		// The parent re-executes itself with this marker set; the child is
+		// the actual daemon.
 		if os.Getenv("WOL_DAEMON_CHILD") == "1" {
-			// We are the child, write PID file and run the server
-			if err := os.WriteFile(pidFile, []byte(fmt.Sprintf("%d", os.Getpid())), 0644); err != nil {
-				fmt.Printf("Failed to write PID file: %v\n", err)
+			lock, err := lockPIDFile(pidFile)
+			if err != nil {
+				fmt.Println("Failed to lock PID file:", err)
+				os.Exit(1)
 			}
-			defer os.Remove(pidFile)
+			defer lock.Release()
+			signalStarted()
 
+			go handleSignals()
 			startServer()
 			return
 		}
 
-		// We are the parent, spawn child
-		exe, err := os.Executable()
-		if err != nil {
-			fmt.Println("Failed to determine executable path:", err)
+		if err := startDaemon(); err != nil {
+			fmt.Println(err)
 			os.Exit(1)
 		}
-
-		cmd := exec.Command(exe)
-		cmd.Env = append(os.Environ(), "WOL_DAEMON_CHILD=1")
-		// Detach process
-		cmd.Start()
-		fmt.Println("WOL Manager started in background.")
-		os.Exit(0)
+		return
 	}
 
 	// Foreground mode
+	go handleSignals()
 	startServer()
 }
 
-func killDaemon() {
-	data, err := os.ReadFile(pidFile)
+// startDaemon spawns a detached child in a new session with its output
+// redirected to daemonLogFile, and waits until it holds the PID file lock.
+func startDaemon() error {
+	if pid, running, err := daemonPID(pidFile); err != nil {
+		return fmt.Errorf("failed to check PID file: %v", err)
+	} else if running {
+		return fmt.Errorf("daemon already running (PID %d)", pid)
+	}
+
+	exe, err := os.Executable()
 	if err != nil {
-		fmt.Println("Failed to read PID file (is the daemon running?):", err)
-		return
+		return fmt.Errorf("failed to determine executable path: %v", err)
 	}
-	pid, err := strconv.Atoi(string(data))
+
+	out, err := os.OpenFile(daemonLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		fmt.Println("Invalid PID file content:", err)
-		return
+		return fmt.Errorf("failed to open %s: %v", daemonLogFile, err)
 	}
+	defer out.Close()
 
-	proc, err := os.FindProcess(pid)
+	// The child reports on this pipe once it holds the PID file lock. Polling
+	// the file instead could catch it before the lock is taken.
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to start daemon: %v", err)
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(exe, "-d", "-config", *configFile)
+	cmd.Env = append(os.Environ(), "WOL_DAEMON_CHILD=1", "WOL_DAEMON_READY_FD=3")
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.ExtraFiles = []*os.File{readyW} // fd 3 in the child
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return fmt.Errorf("failed to start daemon: %v", err)
+	}
+
+	started := make(chan bool, 1)
+	go func() {
+		// EOF without a byte means the child exited first
+		n, _ := ready.Read(make([]byte, 1))
+		started <- n == 1
+	}()
+	select {
+	case ok := <-started:
+		if !ok {
+			return fmt.Errorf("daemon exited during startup, see %s", daemonLogFile)
+		}
+		fmt.Printf("WOL Manager started in background (PID %d).\n", cmd.Process.Pid)
+		return nil
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("daemon did not start within 5s, see %s", daemonLogFile)
+	}
+}
+
+// signalStarted tells the parent waiting in startDaemon that this daemon
+// holds the PID file lock.
+func signalStarted() {
+	fd, err := strconv.Atoi(os.Getenv("WOL_DAEMON_READY_FD"))
+	os.Unsetenv("WOL_DAEMON_READY_FD")
 	if err != nil {
-		fmt.Println("Failed to find process:", err)
 		return
 	}
+	f := os.NewFile(uintptr(fd), "ready")
+	f.Write([]byte{1})
+	f.Close()
+}
+
+// stopDaemon sends SIGTERM to the running daemon and waits for it to release
+// the PID file. It is not an error if no daemon is running.
+func stopDaemon() error {
+	pid, running, err := daemonPID(pidFile)
+	if err != nil {
+		return fmt.Errorf("failed to check PID file: %v", err)
+	}
+	if !running {
+		fmt.Println("Daemon is not running.")
+		return nil
+	}
 
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return fmt.Errorf("failed to find process: %v", err)
+	}
 	if err := proc.Signal(syscall.SIGTERM); err != nil {
-		fmt.Println("Failed to kill process:", err)
-		return
+		return fmt.Errorf("failed to stop process: %v", err)
+	}
+
+	deadline := time.Now().Add(shutdownTimeout + 5*time.Second)
+	for time.Now().Before(deadline) {
+		if _, running, _ := daemonPID(pidFile); !running {
+			fmt.Println("Daemon stopped.")
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("daemon (PID %d) did not stop in time", pid)
+}
+
+func daemonStatus() {
+	pid, running, err := daemonPID(pidFile)
+	if err != nil {
+		fmt.Println("Failed to check PID file:", err)
+		os.Exit(1)
+	}
+	if !running {
+		fmt.Println("Daemon is not running.")
+		os.Exit(3)
 	}
-	fmt.Println("Daemon stopped.")
-	os.Remove(pidFile)
+	fmt.Printf("Daemon is running (PID %d).\n", pid)
 }
@@ -55,6 +55,6 @@ func onReady() {
 }
 
 func onExit() {
-	// Cleanup if needed
+	shutdown()
 	os.Exit(0)
 }
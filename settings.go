@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"wol/devicefile"
+	"wol/logger"
+	"wol/storage"
+)
+
+// listenPort is the port the server was started on. A different port in the
+// config only takes effect after a restart.
+var listenPort int
+
+// settingsView is the settings as GET and PUT /api/settings return them.
+// Pending lists the options whose saved value only applies after a restart.
+type settingsView struct {
+	storage.Settings
+	RestartRequired bool     `json:"restart_required"`
+	Pending         []string `json:"pending,omitempty"`
+}
+
+func viewSettings(st storage.Settings) settingsView {
+	v := settingsView{Settings: st}
+	if listenPort != 0 && st.Port != listenPort {
+		v.Pending = append(v.Pending, "port")
+	}
+	v.RestartRequired = len(v.Pending) > 0
+	return v
+}
+
+// checkLeaseSources returns an error if a lease_sync source has a format that
+// doesn't exist or can't be guessed from its file name.
+func checkLeaseSources(sources []storage.LeaseSource) error {
+	for _, src := range sources {
+		if src.Format == "" {
+			if devicefile.FormatFromName(src.File) == "" {
+				return fmt.Errorf("lease_sync: can't tell the format of %s, set \"format\"", src.File)
+			}
+		} else if !slices.Contains(devicefile.Formats, src.Format) {
+			return fmt.Errorf("lease_sync: unknown format %q", src.Format)
+		}
+	}
+	return nil
+}
+
+// applySettings puts the options that can change at runtime into effect.
+// The API token, lease_sync and backup_count are read from the store when
+// used and need nothing here.
+func applySettings(old, st storage.Settings) {
+	if st.LogDir != old.LogDir || st.LogRetentionDays != old.LogRetentionDays {
+		if err := logger.Configure(st.LogDir, st.LogRetentionDays); err != nil {
+			logger.Error("System", fmt.Sprintf("Failed to switch the log directory to %s: %v", st.LogDir, err))
+		}
+	}
+}
+
+func handleSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(viewSettings(store.GetSettings()))
+	case http.MethodPut:
+		var st storage.Settings
+		if err := json.NewDecoder(r.Body).Decode(&st); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := st.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := checkLeaseSources(st.LeaseSync); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		old := store.GetSettings()
+		st, err := store.UpdateSettings(actorOf(r), st)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		applySettings(old, st)
+		v := viewSettings(st)
+		if v.RestartRequired {
+			logger.Info("System", "Settings updated, restart required for: "+strings.Join(v.Pending, ", "))
+		} else {
+			logger.Info("System", "Settings updated")
+		}
+		json.NewEncoder(w).Encode(v)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"wol/logger"
+	"wol/storage"
+)
+
+// shutdownTimeout bounds how long in-flight requests and jobs may run after a
+// shutdown has been requested before they are cancelled.
+const shutdownTimeout = 10 * time.Second
+
+var (
+	jobs         = newJobGroup()
+	shutdownOnce sync.Once
+	shutdownDone = make(chan struct{})
+
+	// background is cancelled as soon as shutdown starts. Long-running loops
+	// and streams use it so they don't hold up draining.
+	background, stopBackground = context.WithCancel(context.Background())
+)
+
+// jobGroup tracks wakes and other background work so that shutdown can wait
+// for them to finish, or cancel them once the timeout expires.
+type jobGroup struct {
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	closed bool
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newJobGroup() *jobGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &jobGroup{ctx: ctx, cancel: cancel}
+}
+
+// Start registers a new job. It returns the context the job should honour and
+// a function to call when it is done. ok is false once shutdown has begun.
+func (g *jobGroup) Start() (ctx context.Context, done func(), ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return nil, nil, false
+	}
+	g.wg.Add(1)
+	return g.ctx, g.wg.Done, true
+}
+
+// Go runs fn in a tracked goroutine. It returns false if shutdown has begun.
+func (g *jobGroup) Go(fn func(ctx context.Context)) bool {
+	ctx, done, ok := g.Start()
+	if !ok {
+		return false
+	}
+	go func() {
+		defer done()
+		fn(ctx)
+	}()
+	return true
+}
+
+// Close stops accepting new jobs and waits for running ones. If ctx expires
+// first, the remaining jobs are cancelled and waited for again.
+func (g *jobGroup) Close(ctx context.Context) {
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		g.cancel()
+		<-finished
+	}
+	g.cancel()
+}
+
+// shutdown stops accepting connections, drains in-flight requests, waits for
+// or cancels running jobs and flushes the logger. It is safe to call more
+// than once; later calls wait for the first to complete.
+func shutdown() {
+	shutdownOnce.Do(func() {
+		defer close(shutdownDone)
+
+		logger.Info("System", "Shutting down...")
+		sdNotify("STOPPING=1")
+		stopBackground()
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Error("System", fmt.Sprintf("Server shutdown: %v", err))
+		}
+		jobs.Close(ctx)
+		if err := store.Close(); err != nil {
+			logger.Error("System", fmt.Sprintf("Closing storage: %v", err))
+		}
+
+		logger.Info("System", "Server stopped")
+		logger.Close()
+	})
+	<-shutdownDone
+}
+
+// reloadConfig re-reads wol.json, keeping the current config if it is invalid.
+func reloadConfig() {
+	old := store.GetSettings()
+	reportReload(old, store.Reload())
+}
+
+// watchConfig reloads wol.json whenever it is edited on disk.
+func watchConfig() {
+	jobs.Go(func(context.Context) {
+		old := store.GetSettings()
+		store.Watch(background, func(err error) {
+			reportReload(old, err)
+			old = store.GetSettings()
+		})
+	})
+}
+
+func reportReload(old storage.Settings, err error) {
+	if err != nil {
+		logger.Error("System", fmt.Sprintf("Config reload failed, keeping current config: %v", err))
+		return
+	}
+	logger.Info("System", "Config reloaded")
+	applySettings(old, store.GetSettings())
+	if store.GetPort() != old.Port {
+		logger.Info("System", "Port change takes effect after a restart")
+	}
+}
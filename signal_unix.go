@@ -0,0 +1,31 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"wol/logger"
+)
+
+// handleSignals shuts the server down on SIGINT/SIGTERM and reloads the
+// config on SIGHUP.
+func handleSignals() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	for sig := range sigCh {
+		switch sig {
+		case syscall.SIGHUP:
+			reloadConfig()
+		default:
+			logger.Info("System", fmt.Sprintf("Received %v", sig))
+			signal.Stop(sigCh)
+			shutdown()
+			return
+		}
+	}
+}
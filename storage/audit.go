@@ -0,0 +1,618 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrEventNotFound is returned for an audit event that doesn't exist or
+	// has expired.
+	ErrEventNotFound = errors.New("audit event not found")
+	// ErrAuditExpired is returned when restoring to a time before the audit
+	// log begins.
+	ErrAuditExpired = errors.New("audit log doesn't go back that far")
+)
+
+// Actor is who made a change to the config.
+type Actor struct {
+	Name string `json:"actor"`        // e.g. "web", "cli (alice@nas)" or "lease sync"
+	IP   string `json:"ip,omitempty"` // Source address of API requests
+}
+
+// FileActor is recorded for changes made by editing the config file on disk.
+var FileActor = Actor{Name: "file"}
+
+// Kinds of audit events.
+const (
+	KindDevice   = "device"
+	KindNetwork  = "network"
+	KindSettings = "settings"
+	KindOrder    = "order" // Display order of the devices
+)
+
+// AuditEvent is one recorded change to a device, network, the settings or
+// the device order. Before and After hold the complete object; Before is
+// absent for additions and After for deletions.
+type AuditEvent struct {
+	ID   int64     `json:"id"`
+	Time time.Time `json:"time"`
+	Actor
+	Kind   string          `json:"kind"`
+	Action string          `json:"action"`         // "add", "update" or "delete"
+	Key    string          `json:"key,omitempty"`  // Device ID or network name
+	Name   string          `json:"name,omitempty"` // Device or network name, for display
+	Note   string          `json:"note,omitempty"` // e.g. "import" or "revert of #12"
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+	Diff   []FieldChange   `json:"diff,omitempty"`
+}
+
+// FieldChange is a value that differs between Before and After of an update.
+// Field is a path such as "sub_devices[0].ip"; a missing value is null.
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// redacted stands in for secrets in recorded events. The API token and PIN
+// hashes are of no use to readers of the audit log, and mustn't leak through
+// it.
+const redacted = "(redacted)"
+
+// secrets are the values recorded events leave out, kept to put back when a
+// change is undone.
+type secrets struct {
+	apiToken  string
+	pinHashes map[string]string // By device ID, including trashed devices
+}
+
+func (s *Store) secrets() secrets {
+	sec := secrets{apiToken: s.APIToken, pinHashes: make(map[string]string)}
+	for _, t := range s.Trash {
+		if t.Protection != nil && t.Protection.PINHash != "" {
+			sec.pinHashes[t.ID] = t.Protection.PINHash
+		}
+	}
+	for _, d := range s.Devices {
+		if d.Protection != nil && d.Protection.PINHash != "" {
+			sec.pinHashes[d.ID] = d.Protection.PINHash
+		}
+	}
+	return sec
+}
+
+// snapshot is the config as the audit log sees it: the settings, every
+// network by name and every device by ID, each encoded on its own.
+type snapshot struct {
+	settings     json.RawMessage
+	networks     map[string]json.RawMessage
+	networkOrder []string
+	devices      map[string]json.RawMessage
+	order        []string
+	secrets      secrets
+}
+
+// snapshot encodes the current config. The lock must be held.
+func (s *Store) snapshot() snapshot {
+	sn := snapshot{
+		networks: make(map[string]json.RawMessage, len(s.Networks)),
+		devices:  make(map[string]json.RawMessage, len(s.Devices)),
+		secrets:  s.secrets(),
+	}
+	sn.settings, _ = json.Marshal(s.Settings)
+	for _, n := range s.Networks {
+		sn.networks[n.Name], _ = json.Marshal(n)
+		sn.networkOrder = append(sn.networkOrder, n.Name)
+	}
+	for _, d := range s.Devices {
+		sn.devices[d.ID], _ = json.Marshal(d)
+		sn.order = append(sn.order, d.ID)
+	}
+	return sn
+}
+
+// changes lists the differences from old to sn as audit events, without
+// IDs, times or actors.
+func (sn snapshot) changes(old snapshot) []AuditEvent {
+	var events []AuditEvent
+	if !bytes.Equal(old.settings, sn.settings) {
+		events = append(events, newEvent(KindSettings, "", old.settings, sn.settings))
+	}
+	events = append(events, diffObjects(KindNetwork, old.networkOrder, old.networks, sn.networkOrder, sn.networks)...)
+	events = append(events, diffObjects(KindDevice, old.order, old.devices, sn.order, sn.devices)...)
+
+	// Only a change in the relative order of devices that are kept counts;
+	// additions go to the end and deletions are recorded by themselves.
+	before := slices.DeleteFunc(slices.Clone(old.order), func(id string) bool { return sn.devices[id] == nil })
+	after := slices.DeleteFunc(slices.Clone(sn.order), func(id string) bool { return old.devices[id] == nil })
+	if !slices.Equal(before, after) {
+		b, _ := json.Marshal(old.order)
+		a, _ := json.Marshal(sn.order)
+		events = append(events, AuditEvent{Kind: KindOrder, Action: "update", Before: b, After: a})
+	}
+	return events
+}
+
+func diffObjects(kind string, oldOrder []string, old map[string]json.RawMessage, order []string, objects map[string]json.RawMessage) []AuditEvent {
+	var events []AuditEvent
+	for _, key := range oldOrder {
+		if objects[key] == nil || !bytes.Equal(old[key], objects[key]) {
+			events = append(events, newEvent(kind, key, old[key], objects[key]))
+		}
+	}
+	for _, key := range order {
+		if old[key] == nil {
+			events = append(events, newEvent(kind, key, nil, objects[key]))
+		}
+	}
+	return events
+}
+
+func newEvent(kind, key string, before, after json.RawMessage) AuditEvent {
+	e := AuditEvent{Kind: kind, Key: key, Before: redact(kind, before), After: redact(kind, after)}
+	switch {
+	case before == nil:
+		e.Action = "add"
+	case after == nil:
+		e.Action = "delete"
+	default:
+		e.Action = "update"
+		e.Diff = diffJSON(before, after)
+		for i, c := range e.Diff {
+			if c.Field == secretFields[kind] {
+				e.Diff[i].Old, e.Diff[i].New = redactValue(c.Old), redactValue(c.New)
+			}
+		}
+	}
+	var named struct {
+		Name string `json:"name"`
+	}
+	if after != nil {
+		json.Unmarshal(after, &named)
+	} else {
+		json.Unmarshal(before, &named)
+	}
+	e.Name = named.Name
+	return e
+}
+
+// secretFields are the paths of the secrets in objects of each kind, see
+// redact.
+var secretFields = map[string]string{
+	KindSettings: "api_token",
+	KindDevice:   "protection.pin_hash",
+}
+
+// redact returns state, an object of the given kind, with its secrets
+// replaced by redacted.
+func redact(kind string, state json.RawMessage) json.RawMessage {
+	return replaceSecret(kind, state, func(string) string { return redacted })
+}
+
+func redactValue(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return redacted
+}
+
+// unredact returns state, an object of the given kind from a recorded event,
+// with the secrets of sn in place of redacted ones. Undoing a change thus
+// keeps the current secrets.
+func (sn *snapshot) unredact(kind, key string, state json.RawMessage) json.RawMessage {
+	return replaceSecret(kind, state, func(secret string) string {
+		switch {
+		case secret != redacted:
+			return secret
+		case kind == KindSettings:
+			return sn.secrets.apiToken
+		case sn.secrets.pinHashes[key] != "":
+			return sn.secrets.pinHashes[key]
+		}
+		return secret // Caught by config
+	})
+}
+
+// replaceSecret returns state, an object of the given kind, with its secret
+// replaced by what fn returns for it. Objects without one are returned as
+// they are.
+func replaceSecret(kind string, state json.RawMessage, fn func(string) string) json.RawMessage {
+	if state == nil {
+		return nil
+	}
+	switch kind {
+	case KindSettings:
+		var st Settings
+		if err := json.Unmarshal(state, &st); err != nil || st.APIToken == "" {
+			return state
+		}
+		st.APIToken = fn(st.APIToken)
+		state, _ = json.Marshal(st)
+	case KindDevice:
+		var d Device
+		if err := json.Unmarshal(state, &d); err != nil || d.Protection == nil || d.Protection.PINHash == "" {
+			return state
+		}
+		d.Protection.PINHash = fn(d.Protection.PINHash)
+		state, _ = json.Marshal(d)
+	}
+	return state
+}
+
+// diffJSON lists the leaf values that differ between two JSON documents.
+func diffJSON(before, after json.RawMessage) []FieldChange {
+	var b, a interface{}
+	if before != nil {
+		json.Unmarshal(before, &b)
+	}
+	if after != nil {
+		json.Unmarshal(after, &a)
+	}
+	old := make(map[string]interface{})
+	flatten("", b, old)
+	cur := make(map[string]interface{})
+	flatten("", a, cur)
+
+	fields := make([]string, 0, len(old)+len(cur))
+	for f := range old {
+		fields = append(fields, f)
+	}
+	for f := range cur {
+		if _, ok := old[f]; !ok {
+			fields = append(fields, f)
+		}
+	}
+	sort.Strings(fields)
+
+	var changes []FieldChange
+	for _, f := range fields {
+		if !reflect.DeepEqual(old[f], cur[f]) {
+			changes = append(changes, FieldChange{Field: f, Old: old[f], New: cur[f]})
+		}
+	}
+	return changes
+}
+
+func flatten(path string, v interface{}, out map[string]interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			if path == "" {
+				flatten(k, val, out)
+			} else {
+				flatten(path+"."+k, val, out)
+			}
+		}
+	case []interface{}:
+		for i, val := range v {
+			flatten(path+"["+strconv.Itoa(i)+"]", val, out)
+		}
+	case nil:
+	default:
+		out[path] = v
+	}
+}
+
+// set replaces one object of the snapshot with state, or removes it if state
+// is nil. Objects that come back are added at the end.
+func (sn *snapshot) set(kind, key string, state json.RawMessage) {
+	state = sn.unredact(kind, key, state)
+	switch kind {
+	case KindSettings:
+		sn.settings = state
+	case KindOrder:
+		var ids []string
+		json.Unmarshal(state, &ids)
+		order := slices.DeleteFunc(ids, func(id string) bool { return sn.devices[id] == nil })
+		for _, id := range sn.order {
+			if !slices.Contains(order, id) {
+				order = append(order, id)
+			}
+		}
+		sn.order = order
+	case KindNetwork:
+		sn.networkOrder = setObject(sn.networks, sn.networkOrder, key, state)
+	case KindDevice:
+		sn.order = setObject(sn.devices, sn.order, key, state)
+	}
+}
+
+func setObject(objects map[string]json.RawMessage, order []string, key string, state json.RawMessage) []string {
+	_, exists := objects[key]
+	switch {
+	case state == nil:
+		delete(objects, key)
+		return slices.DeleteFunc(order, func(k string) bool { return k == key })
+	case !exists:
+		order = append(order, key)
+	}
+	objects[key] = state
+	return order
+}
+
+// config decodes the snapshot and checks that it is a valid config.
+func (sn snapshot) config() (*Store, error) {
+	fresh := &Store{}
+	if err := json.Unmarshal(sn.settings, &fresh.Settings); err != nil {
+		return nil, err
+	}
+	for _, name := range sn.networkOrder {
+		var n Network
+		if err := json.Unmarshal(sn.networks[name], &n); err != nil {
+			return nil, err
+		}
+		fresh.Networks = append(fresh.Networks, n)
+	}
+	fresh.Devices = []Device{}
+	names := make(map[string]bool)
+	for _, id := range sn.order {
+		var d Device
+		if err := json.Unmarshal(sn.devices[id], &d); err != nil {
+			return nil, err
+		}
+		if names[d.Name] {
+			return nil, fmt.Errorf("device with name %q already exists", d.Name)
+		}
+		if d.Protection != nil && d.Protection.PINHash == redacted {
+			return nil, fmt.Errorf("the PIN of %q isn't kept in the history, protect it again instead", d.Name)
+		}
+		names[d.Name] = true
+		if err := checkMACs(fresh.Devices, -1, d); err != nil {
+			return nil, err
+		}
+		fresh.Devices = append(fresh.Devices, d)
+	}
+	if err := fresh.validate(); err != nil {
+		return nil, err
+	}
+	return fresh, nil
+}
+
+// commit saves the config and records what changed since the last commit as
+// made by by, in a single transaction where the backend has them. A change
+// that couldn't be saved isn't recorded; it stays pending until a later
+// commit saves it. The lock must be held.
+func (s *Store) commit(by Actor, note string) error {
+	sn, events := s.pendingEvents(by, note)
+	err := s.backend.save(s, events)
+	s.notify()
+	var partial *partialSaveError
+	if err != nil && !errors.As(err, &partial) {
+		return err
+	}
+	if pruneErr := s.recorded(sn, events); pruneErr != nil && err == nil {
+		return fmt.Errorf("config saved, but pruning the audit log failed: %w", pruneErr)
+	}
+	return err
+}
+
+// record appends the changes since the last snapshot to the audit log, for
+// a config that is already stored, such as one edited on disk. Nothing is
+// taken as recorded unless the events could be stored. The lock must be
+// held.
+func (s *Store) record(by Actor, note string) error {
+	sn, events := s.pendingEvents(by, note)
+	if len(events) > 0 {
+		if err := s.backend.appendAudit(events); err != nil {
+			return err
+		}
+	}
+	return s.recorded(sn, events)
+}
+
+// pendingEvents returns the current snapshot, and the changes since the last
+// recorded one as events made by by. The lock must be held.
+func (s *Store) pendingEvents(by Actor, note string) (snapshot, []AuditEvent) {
+	sn := s.snapshot()
+	events := sn.changes(s.saved)
+	now := time.Now()
+	for i := range events {
+		events[i].ID = s.lastEventID + int64(i) + 1
+		events[i].Time = now
+		events[i].Actor = by
+		events[i].Note = note
+	}
+	return sn, events
+}
+
+// recorded takes sn as the last recorded state once events are stored, and
+// drops events older than audit_retention_days. The lock must be held.
+func (s *Store) recorded(sn snapshot, events []AuditEvent) error {
+	if len(events) > 0 {
+		s.lastEventID = events[len(events)-1].ID
+		s.audit = append(s.audit, events...)
+	}
+	s.saved = sn
+	return s.pruneAudit()
+}
+
+// loadAudit reads the audit log and takes the current config as the state
+// later changes are compared against.
+func (s *Store) loadAudit() error {
+	events, err := s.backend.loadAudit()
+	if err != nil {
+		return fmt.Errorf("reading audit log: %w", err)
+	}
+	s.audit = events
+	if len(events) > 0 {
+		s.lastEventID = events[len(events)-1].ID
+	}
+	s.saved = s.snapshot()
+	return s.pruneAudit()
+}
+
+// catchUpAudit reads events that another process, such as the command line
+// working on the same file, appended to the audit log, and counts their
+// changes as recorded so they aren't recorded again. The lock must be held.
+func (s *Store) catchUpAudit() error {
+	events, err := s.backend.loadAudit()
+	if err != nil {
+		return err
+	}
+	// The events leave out secrets, which the config just read has
+	s.saved.secrets = s.secrets()
+	for _, e := range events {
+		if e.ID <= s.lastEventID {
+			continue
+		}
+		s.audit = append(s.audit, e)
+		s.lastEventID = e.ID
+		s.saved.set(e.Kind, e.Key, e.After)
+	}
+	return nil
+}
+
+func (s *Store) auditCutoff() time.Time {
+	return time.Now().AddDate(0, 0, -s.AuditRetentionDays)
+}
+
+func (s *Store) pruneAudit() error {
+	cutoff := s.auditCutoff()
+	n := 0
+	for n < len(s.audit) && s.audit[n].Time.Before(cutoff) {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	s.audit = append([]AuditEvent(nil), s.audit[n:]...)
+	return s.backend.pruneAudit(cutoff)
+}
+
+// AuditLog returns recorded changes, newest first. If key is set only those
+// to that device (by ID or name) or network are included. limit <= 0 means
+// no limit.
+func (s *Store) AuditLog(key string, limit int) []AuditEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if i := s.indexOf(key); i >= 0 {
+		key = s.Devices[i].ID
+	}
+	events := []AuditEvent{}
+	for i := len(s.audit) - 1; i >= 0; i-- {
+		if limit > 0 && len(events) == limit {
+			break
+		}
+		if e := s.audit[i]; key == "" || e.Key == key {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// AuditEvent returns the recorded change with the given ID.
+func (s *Store) AuditEvent(id int64) (AuditEvent, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := s.eventIndex(id)
+	if i < 0 {
+		return AuditEvent{}, false
+	}
+	return s.audit[i], true
+}
+
+func (s *Store) eventIndex(id int64) int {
+	i, found := slices.BinarySearchFunc(s.audit, id, func(e AuditEvent, id int64) int {
+		return cmp.Compare(e.ID, id)
+	})
+	if !found {
+		return -1
+	}
+	return i
+}
+
+// Revert undoes a single recorded change: the object it changed is put back
+// the way it was before, as long as the result is a valid config. Later
+// changes to the same object are undone with it. The revert is itself
+// recorded.
+func (s *Store) Revert(by Actor, id int64) (AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.eventIndex(id)
+	if i < 0 {
+		return AuditEvent{}, ErrEventNotFound
+	}
+	e := s.audit[i]
+	sn := s.snapshot()
+	sn.set(e.Kind, e.Key, e.Before)
+	fresh, err := sn.config()
+	if err != nil {
+		return AuditEvent{}, fmt.Errorf("can't revert #%d: %w", id, err)
+	}
+	s.replaceConfig(fresh)
+	return e, s.commit(by, fmt.Sprintf("revert of #%d", id))
+}
+
+// Restore returns the whole config to how it was right after the recorded
+// change with the given ID, or before any recorded change if id is 0, by
+// undoing every later change. It returns the number of changes undone.
+func (s *Store) Restore(by Actor, id int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := 0
+	if id != 0 {
+		i := s.eventIndex(id)
+		if i < 0 {
+			return 0, ErrEventNotFound
+		}
+		start = i + 1
+	}
+	sn := s.snapshot()
+	for i := len(s.audit) - 1; i >= start; i-- {
+		e := s.audit[i]
+		sn.set(e.Kind, e.Key, e.Before)
+	}
+	fresh, err := sn.config()
+	if err != nil {
+		return 0, fmt.Errorf("can't restore: %w", err)
+	}
+	s.replaceConfig(fresh)
+	return len(s.audit) - start, s.commit(by, fmt.Sprintf("restore to #%d", id))
+}
+
+// EventAt returns the ID of the last change recorded at or before t, or 0 if
+// there is none. It fails with ErrAuditExpired if older changes may already
+// have been dropped from the log.
+func (s *Store) EventAt(t time.Time) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if t.Before(s.auditCutoff()) {
+		return 0, fmt.Errorf("%w (audit_retention_days is %d)", ErrAuditExpired, s.AuditRetentionDays)
+	}
+	var id int64
+	for _, e := range s.audit {
+		if e.Time.After(t) {
+			break
+		}
+		id = e.ID
+	}
+	return id, nil
+}
+
+// replaceConfig takes over the settings, networks and devices of a restored
+// config. Devices that are back leave the trash. The lock must be held.
+func (s *Store) replaceConfig(fresh *Store) {
+	s.Settings = fresh.Settings
+	s.Networks = fresh.Networks
+	s.Devices = fresh.Devices
+	s.Trash = slices.DeleteFunc(slices.Clone(s.Trash), func(t TrashedDevice) bool {
+		return indexIn(s.Devices, t.ID) >= 0
+	})
+	if len(s.Trash) == 0 {
+		s.Trash = nil
+	}
+}
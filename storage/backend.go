@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// backend persists the config held by a Store. Its methods are called with
+// the store's lock held, except watch.
+type backend interface {
+	// load reads the stored config into s. An error for which os.IsNotExist
+	// is true means nothing has been stored yet.
+	load(s *Store) error
+	// reload reads the stored config into fresh if it changed since it was
+	// last loaded or saved, and reports whether it did.
+	reload(fresh *Store) (bool, error)
+	// save writes the config of s, and adds events to the audit log. Where
+	// the backend has transactions both happen in one.
+	save(s *Store, events []AuditEvent) error
+	// watch calls changed whenever the stored config may have been changed
+	// from outside the program, until ctx is done.
+	watch(ctx context.Context, changed func())
+	// loadAudit reads the audit log, oldest event first.
+	loadAudit() ([]AuditEvent, error)
+	// appendAudit adds events to the audit log on their own, for changes
+	// that are already stored.
+	appendAudit(events []AuditEvent) error
+	// pruneAudit drops the events recorded before cutoff.
+	pruneAudit(cutoff time.Time) error
+	close() error
+}
+
+// partialSaveError is returned by backend.save for a failure after the config
+// itself was stored, such as failing to back it up.
+type partialSaveError struct {
+	err error
+}
+
+func (e *partialSaveError) Error() string { return "config saved, but " + e.err.Error() }
+func (e *partialSaveError) Unwrap() error { return e.err }
+
+// isDatabase reports whether filename selects the embedded database backend
+// rather than a JSON file.
+func isDatabase(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".db", ".bolt":
+		return true
+	}
+	return false
+}
+
+// importSource is the JSON config file a new database is filled from, e.g.
+// wol.json for wol.db.
+func importSource(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ".json"
+}
+
+// Exists reports whether a config is stored at filename. For a database that
+// hasn't been created yet, the JSON file it would import counts.
+func Exists(filename string) bool {
+	if _, err := os.Stat(filename); err == nil {
+		return true
+	}
+	if !isDatabase(filename) {
+		return false
+	}
+	_, err := os.Stat(importSource(filename))
+	return err == nil
+}
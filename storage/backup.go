@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupTimeFormat sorts lexically in chronological order.
+const backupTimeFormat = "20060102-150405.000"
+
+// writeFileAtomic writes data to a temp file in the same directory, fsyncs it
+// and renames it over path, so readers see either the old or the new content
+// and never a truncated file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself. Directories can't be opened for syncing on
+	// every platform, so failures here are ignored.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// backupDir holds the rotating backups of the config file.
+func (j *jsonFile) backupDir() string {
+	return filepath.Join(filepath.Dir(j.filename), "backups")
+}
+
+// backupPrefix returns the prefix and suffix around the timestamp in backup
+// names, e.g. "wol-20240102-150405.000.json" for "wol.json".
+func (j *jsonFile) backupPrefix() (string, string) {
+	base := filepath.Base(j.filename)
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "-", ext
+}
+
+// writeBackup stores data, the content just written to the config file, in
+// the backup directory and prunes old backups down to count.
+func (j *jsonFile) writeBackup(data []byte, count int) error {
+	if count < 0 {
+		return nil
+	}
+	if err := os.MkdirAll(j.backupDir(), 0755); err != nil {
+		return err
+	}
+	prefix, suffix := j.backupPrefix()
+	name := prefix + time.Now().Format(backupTimeFormat) + suffix
+	if err := writeFileAtomic(filepath.Join(j.backupDir(), name), data, 0644); err != nil {
+		return err
+	}
+
+	backups, err := j.listBackups()
+	if err != nil {
+		return err
+	}
+	for i := count; i < len(backups); i++ {
+		os.Remove(backups[i])
+	}
+	return nil
+}
+
+// listBackups returns the backup files, newest first.
+func (j *jsonFile) listBackups() ([]string, error) {
+	entries, err := os.ReadDir(j.backupDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	prefix, suffix := j.backupPrefix()
+	var backups []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		backups = append(backups, filepath.Join(j.backupDir(), name))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
+
+// restoreBackup replaces a corrupt config file with the newest backup that
+// parses and loads it into s. The corrupt file is kept next to it for
+// inspection. It returns the path of the backup used.
+func (j *jsonFile) restoreBackup(s *Store) (string, error) {
+	backups, err := j.listBackups()
+	if err != nil {
+		return "", err
+	}
+
+	for _, path := range backups {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// Backups may predate the current schema
+		if data, _, err = j.migrate(data); err != nil {
+			continue
+		}
+		fresh := &Store{}
+		if err := json.Unmarshal(data, fresh); err != nil {
+			continue
+		}
+		fresh.applyDefaults()
+
+		corrupt := j.filename + ".corrupt-" + time.Now().Format(backupTimeFormat)
+		if err := os.Rename(j.filename, corrupt); err != nil {
+			return "", err
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.copyConfig(fresh)
+		if err := s.saveInternal(); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+	return "", fmt.Errorf("no usable backup in %s", j.backupDir())
+}
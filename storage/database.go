@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Layout of the embedded database. Settings and the display order live in
+// the config bucket; each device is stored under its ID so an edit only
+// rewrites that device. Further data gets buckets of its own.
+var (
+	configBucket  = []byte("config")
+	devicesBucket = []byte("devices")
+	auditBucket   = []byte("audit") // Events keyed by their big-endian ID
+	settingsKey   = []byte("settings")
+	orderKey      = []byte("order")
+)
+
+// database is the embedded bbolt backend. Writes are transactional, and the
+// file is locked so only one process can open it at a time.
+type database struct {
+	db *bolt.DB
+}
+
+func openDatabase(filename string) (*database, error) {
+	db, err := bolt.Open(filename, 0644, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%s is in use by another process (is the server running?)", filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &database{db: db}, nil
+}
+
+// openDatabase loads the config from the database. A new database is filled
+// from the JSON config file next to it, e.g. wol.json for wol.db, which is
+// then renamed so it isn't mistaken for the live config.
+func (s *Store) openDatabase(filename string) error {
+	err := s.Load()
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	jsonName := importSource(filename)
+	if _, err := os.Stat(jsonName); err != nil {
+		return s.Save()
+	}
+	if err := (&jsonFile{filename: jsonName}).load(s); err != nil {
+		return fmt.Errorf("importing %s: %w", jsonName, err)
+	}
+	if err := s.validate(); err != nil {
+		return fmt.Errorf("importing %s: %w", jsonName, err)
+	}
+	assignIDs(s.Devices, nil)
+	if err := s.Save(); err != nil {
+		return err
+	}
+	s.importedFrom = jsonName
+	return os.Rename(jsonName, jsonName+".imported")
+}
+
+func (d *database) load(s *Store) error {
+	return d.db.View(func(tx *bolt.Tx) error {
+		config := tx.Bucket(configBucket)
+		if config == nil {
+			return os.ErrNotExist
+		}
+		if err := json.Unmarshal(config.Get(settingsKey), s); err != nil {
+			return fmt.Errorf("reading settings: %w", err)
+		}
+		if s.SchemaVersion > CurrentSchemaVersion {
+			return fmt.Errorf("%w (%d > %d)", ErrNewerSchema, s.SchemaVersion, CurrentSchemaVersion)
+		}
+
+		var order []string
+		if err := json.Unmarshal(config.Get(orderKey), &order); err != nil {
+			return fmt.Errorf("reading device order: %w", err)
+		}
+		devices := tx.Bucket(devicesBucket)
+		s.Devices = make([]Device, 0, len(order))
+		for _, id := range order {
+			var dev Device
+			if err := json.Unmarshal(devices.Get([]byte(id)), &dev); err != nil {
+				return fmt.Errorf("reading device %s: %w", id, err)
+			}
+			s.Devices = append(s.Devices, dev)
+		}
+		return nil
+	})
+}
+
+// reload has nothing to do: the database is locked by this process, so it
+// can't change underneath it.
+func (d *database) reload(fresh *Store) (bool, error) {
+	return false, nil
+}
+
+// save writes the settings and order, only those devices that changed, and
+// events, all in one transaction.
+func (d *database) save(s *Store, events []AuditEvent) error {
+	settings, err := settingsJSON(s)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(s.Devices))
+	for i, dev := range s.Devices {
+		ids[i] = dev.ID
+	}
+	order, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+
+	return d.db.Update(func(tx *bolt.Tx) error {
+		config, err := tx.CreateBucketIfNotExists(configBucket)
+		if err != nil {
+			return err
+		}
+		if err := config.Put(settingsKey, settings); err != nil {
+			return err
+		}
+		if err := config.Put(orderKey, order); err != nil {
+			return err
+		}
+
+		devices, err := tx.CreateBucketIfNotExists(devicesBucket)
+		if err != nil {
+			return err
+		}
+		keep := make(map[string]bool, len(s.Devices))
+		for _, dev := range s.Devices {
+			keep[dev.ID] = true
+			data, err := json.Marshal(dev)
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(devices.Get([]byte(dev.ID)), data) {
+				continue
+			}
+			if err := devices.Put([]byte(dev.ID), data); err != nil {
+				return err
+			}
+		}
+
+		// Keys can't be deleted while iterating
+		var stale [][]byte
+		devices.ForEach(func(k, _ []byte) error {
+			if !keep[string(k)] {
+				stale = append(stale, k)
+			}
+			return nil
+		})
+		for _, k := range stale {
+			if err := devices.Delete(k); err != nil {
+				return err
+			}
+		}
+		return putAudit(tx, events)
+	})
+}
+
+// settingsJSON encodes everything in the config except the devices.
+func settingsJSON(s *Store) ([]byte, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	delete(fields, "devices")
+	delete(fields, "$schema")
+	return json.Marshal(fields)
+}
+
+func (d *database) loadAudit() ([]AuditEvent, error) {
+	var events []AuditEvent
+	err := d.db.View(func(tx *bolt.Tx) error {
+		audit := tx.Bucket(auditBucket)
+		if audit == nil {
+			return nil
+		}
+		return audit.ForEach(func(_, v []byte) error {
+			var e AuditEvent
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			events = append(events, e)
+			return nil
+		})
+	})
+	return events, err
+}
+
+func (d *database) appendAudit(events []AuditEvent) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		return putAudit(tx, events)
+	})
+}
+
+// putAudit stores events in the audit bucket of tx.
+func putAudit(tx *bolt.Tx, events []AuditEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	audit, err := tx.CreateBucketIfNotExists(auditBucket)
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := audit.Put(binary.BigEndian.AppendUint64(nil, uint64(e.ID)), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *database) pruneAudit(cutoff time.Time) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		audit := tx.Bucket(auditBucket)
+		if audit == nil {
+			return nil
+		}
+		var stale [][]byte
+		c := audit.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var e AuditEvent
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if !e.Time.Before(cutoff) {
+				break
+			}
+			stale = append(stale, k)
+		}
+		for _, k := range stale {
+			if err := audit.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (d *database) watch(ctx context.Context, changed func()) {}
+
+func (d *database) close() error {
+	return d.db.Close()
+}
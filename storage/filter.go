@@ -0,0 +1,93 @@
+package storage
+
+import "strings"
+
+// Filter selects devices. The zero Filter matches every device.
+type Filter struct {
+	Tags   []string // All of these, regardless of case
+	Folder string   // This folder or one below it
+	Query  string   // Text found, regardless of case, in the name, a tag, the folder or a member's MAC, IP or remark
+}
+
+// Clean returns f with its tags and folder in the form Match compares them
+// in: blank and repeated tags dropped and the folder run through cleanFolder.
+// Whether it selects every device can then be read off its fields.
+func (f Filter) Clean() Filter {
+	return Filter{Tags: cleanTags(f.Tags), Folder: cleanFolder(f.Folder), Query: strings.TrimSpace(f.Query)}
+}
+
+// Match reports whether d is selected by f.
+func (f Filter) Match(d Device) bool {
+	for _, tag := range f.Tags {
+		if strings.TrimSpace(tag) != "" && !d.HasTag(tag) {
+			return false
+		}
+	}
+	if folder := cleanFolder(f.Folder); folder != "" && !InFolder(d.Folder, folder) {
+		return false
+	}
+	if q := strings.ToLower(strings.TrimSpace(f.Query)); q != "" {
+		fields := []string{d.Name, d.Folder, d.MAC, d.IP}
+		fields = append(fields, d.Tags...)
+		for _, sub := range d.SubDevices {
+			fields = append(fields, sub.MAC, sub.IP, sub.Remark)
+		}
+		found := false
+		for _, field := range fields {
+			if strings.Contains(strings.ToLower(field), q) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// HasTag reports whether d is tagged with tag, regardless of case.
+func (d Device) HasTag(tag string) bool {
+	for _, t := range d.Tags {
+		if strings.EqualFold(t, strings.TrimSpace(tag)) {
+			return true
+		}
+	}
+	return false
+}
+
+// InFolder reports whether folder is parent or one of its subfolders, case
+// insensitively.
+func InFolder(folder, parent string) bool {
+	folder, parent = strings.ToLower(folder), strings.ToLower(parent)
+	return folder == parent || strings.HasPrefix(folder, parent+"/")
+}
+
+// cleanTags trims tags and drops empty and repeated ones, keeping the first
+// spelling of each.
+func cleanTags(tags []string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		key := strings.ToLower(tag)
+		if tag == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, tag)
+	}
+	return out
+}
+
+// cleanFolder trims each level of a folder path and drops empty ones, so
+// " Office / /Floor 2/" becomes "Office/Floor 2".
+func cleanFolder(folder string) string {
+	var levels []string
+	for _, level := range strings.Split(folder, "/") {
+		if level = strings.TrimSpace(level); level != "" {
+			levels = append(levels, level)
+		}
+	}
+	return strings.Join(levels, "/")
+}
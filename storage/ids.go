@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// newID returns a random identifier for a device or sub-device. IDs never
+// change once assigned, so API paths, bookmarks and log filters survive
+// renames.
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// assignIDs gives every device and sub-device without an ID (or with a
+// duplicate one) a fresh ID. Devices that exist in previous under the same
+// name keep their ID, so a hand-edited file without IDs doesn't change them
+// on reload. It reports whether anything was assigned.
+func assignIDs(devices []Device, previous []Device) bool {
+	prevIDs := make(map[string]string)
+	for _, d := range previous {
+		prevIDs[d.Name] = d.ID
+	}
+
+	changed := false
+	seen := make(map[string]bool)
+	for i := range devices {
+		d := &devices[i]
+		if d.ID == "" || seen[d.ID] {
+			if id := prevIDs[d.Name]; id != "" && !seen[id] {
+				d.ID = id
+			} else {
+				d.ID = newID()
+			}
+			changed = true
+		}
+		seen[d.ID] = true
+
+		for j := range d.SubDevices {
+			sub := &d.SubDevices[j]
+			if sub.ID == "" || seen[sub.ID] {
+				sub.ID = newID()
+				changed = true
+			}
+			seen[sub.ID] = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"reflect"
+	"slices"
+)
+
+// ImportPlan describes what importing a device list does, or did.
+type ImportPlan struct {
+	DryRun    bool             `json:"dry_run"`
+	Added     []Device         `json:"added"`
+	Updated   []DeviceChange   `json:"updated"`
+	Unchanged int              `json:"unchanged"`
+	Conflicts []ImportConflict `json:"conflicts"`
+}
+
+// DeviceChange is a device before and after an import.
+type DeviceChange struct {
+	Old Device `json:"old"`
+	New Device `json:"new"`
+}
+
+// ImportConflict is an imported device that was skipped.
+type ImportConflict struct {
+	Device Device `json:"device"`
+	Reason string `json:"reason"`
+}
+
+// Import merges devices into the config and saves it once. With dryRun the
+// config is left alone and only the plan is returned.
+//
+// An imported device matches an existing one by ID, then by name, then by the
+// MAC of any member. Matched devices keep their name (unless matched by ID)
+// and their members; members with a known MAC get the imported IP, port,
+// broadcast address and remark where those are set, and new MACs are added as
+// members. Imported tags and group members are added and a set folder
+// replaces the old one. Groups may reference existing devices by ID or name.
+// Nothing is ever removed. Devices that can't be merged cleanly, such
+// as one whose MACs belong to two different devices, are reported as
+// conflicts and skipped.
+func (s *Store) Import(by Actor, devices []Device, dryRun bool) (ImportPlan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	plan := ImportPlan{
+		DryRun:    dryRun,
+		Added:     []Device{},
+		Updated:   []DeviceChange{},
+		Conflicts: []ImportConflict{},
+	}
+	working := make([]Device, len(s.Devices))
+	copy(working, s.Devices)
+	var updated []int // Indexes in working, in the order first changed
+	old := make(map[int]Device)
+
+	for _, in := range devices {
+		in.normalize()
+		if reason := importConflict(working, s.Networks, &in); reason != "" {
+			plan.Conflicts = append(plan.Conflicts, ImportConflict{Device: in, Reason: reason})
+			continue
+		}
+
+		// New devices and members get their IDs once committed
+		idx := matchDevice(working, in)
+		if idx < 0 {
+			d := withDefaults(in)
+			d.ID = ""
+			for i := range d.SubDevices {
+				d.SubDevices[i].ID = ""
+			}
+			working = append(working, d)
+			continue
+		}
+
+		merged := mergeDevice(working[idx], in)
+		if reflect.DeepEqual(merged, working[idx]) {
+			if _, changed := old[idx]; !changed && idx < len(s.Devices) {
+				plan.Unchanged++
+			}
+			continue
+		}
+		if _, changed := old[idx]; !changed && idx < len(s.Devices) {
+			old[idx] = working[idx]
+			updated = append(updated, idx)
+		}
+		working[idx] = merged
+	}
+
+	changed := len(working) > len(s.Devices) || len(updated) > 0
+	if changed && !dryRun {
+		assignIDs(working, nil)
+	}
+	plan.Added = append(plan.Added, working[len(s.Devices):]...)
+	for _, idx := range updated {
+		plan.Updated = append(plan.Updated, DeviceChange{Old: old[idx], New: working[idx]})
+	}
+	if !changed || dryRun {
+		return plan, nil
+	}
+	s.Devices = working
+	return plan, s.commit(by, "import")
+}
+
+// importConflict returns why in can't be imported into devices, or "". The
+// groups in references by name are replaced by their IDs.
+func importConflict(devices []Device, networks []Network, in *Device) string {
+	if in.Name == "" {
+		return "device name is required"
+	}
+	d := withDefaults(*in)
+	if err := d.Validate(); err != nil {
+		return err.Error()
+	}
+
+	idx := matchDevice(devices, *in)
+	if err := checkMACs(devices, idx, *in); err != nil {
+		return err.Error()
+	}
+	if err := resolveMembers(devices, idx, in); err != nil {
+		return err.Error()
+	}
+	if err := checkNetworks(networks, *in); err != nil {
+		return err.Error()
+	}
+	if idx >= 0 && in.Name != devices[idx].Name && in.ID != "" && in.ID == devices[idx].ID {
+		for i, dev := range devices {
+			if i != idx && dev.Name == in.Name {
+				return "device with this name already exists"
+			}
+		}
+	}
+	return ""
+}
+
+// matchDevice returns the index of the device in devices that in updates, or
+// -1 if it is new.
+func matchDevice(devices []Device, in Device) int {
+	if in.ID != "" {
+		for i, d := range devices {
+			if d.ID == in.ID {
+				return i
+			}
+		}
+	}
+	for i, d := range devices {
+		if d.Name == in.Name {
+			return i
+		}
+	}
+	for _, sub := range in.SubDevices {
+		for i, d := range devices {
+			if hasMAC(d, sub.MAC) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// mergeDevice applies in to old as described for Import.
+func mergeDevice(old, in Device) Device {
+	d := old
+	d.SubDevices = append([]SubDevice(nil), old.SubDevices...)
+	if in.ID != "" && in.ID == old.ID {
+		d.Name = in.Name
+	}
+	if in.PingMode != "" {
+		d.PingMode = in.PingMode
+	}
+	if in.Folder != "" {
+		d.Folder = in.Folder
+	}
+	d.Tags = cleanTags(append(append([]string(nil), old.Tags...), in.Tags...))
+	d.Members = append([]string(nil), old.Members...)
+	for _, id := range in.Members {
+		if !slices.Contains(d.Members, id) {
+			d.Members = append(d.Members, id)
+		}
+	}
+
+	for _, sub := range in.SubDevices {
+		found := false
+		for i := range d.SubDevices {
+			member := &d.SubDevices[i]
+			if !sameMAC(member.MAC, sub.MAC) {
+				continue
+			}
+			found = true
+			if sub.IP != "" {
+				member.IP = sub.IP
+			}
+			if sub.Port != 0 {
+				member.Port = sub.Port
+			}
+			if sub.BroadcastIP != "" {
+				member.BroadcastIP = sub.BroadcastIP
+			}
+			if sub.Remark != "" {
+				member.Remark = sub.Remark
+			}
+			if sub.Network != "" {
+				member.Network = sub.Network
+			}
+		}
+		if !found {
+			sub = withDefaults(Device{SubDevices: []SubDevice{sub}}).SubDevices[0]
+			sub.ID = ""
+			d.SubDevices = append(d.SubDevices, sub)
+		}
+	}
+	return d
+}
+
+// withDefaults returns a copy of d with the default port filled in for
+// members that don't set one and aren't on a network.
+func withDefaults(d Device) Device {
+	d.SubDevices = append([]SubDevice(nil), d.SubDevices...)
+	for i := range d.SubDevices {
+		if d.SubDevices[i].Port == 0 && d.SubDevices[i].Network == "" {
+			d.SubDevices[i].Port = 9
+		}
+	}
+	return d
+}
+
+func hasMAC(d Device, mac string) bool {
+	for _, sub := range d.SubDevices {
+		if sameMAC(sub.MAC, mac) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameMAC compares MAC addresses regardless of case and separators.
+func sameMAC(a, b string) bool {
+	return canonicalMAC(a) == canonicalMAC(b)
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// jsonFile is the default backend, a JSON file such as wol.json that can also
+// be edited by hand.
+type jsonFile struct {
+	filename string
+	hash     [sha256.Size]byte // Content last read from or written to filename
+}
+
+func (j *jsonFile) load(s *Store) error {
+	data, err := os.ReadFile(j.filename)
+	if err != nil {
+		return err
+	}
+	j.hash = sha256.Sum256(data)
+
+	data, s.migrated, err = j.migrate(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, s)
+}
+
+// reload skips files identical to what was last read or written, so the
+// store's own saves don't come back as changes. A file that doesn't parse or
+// validate is rejected without being recorded as seen.
+func (j *jsonFile) reload(fresh *Store) (bool, error) {
+	data, err := os.ReadFile(j.filename)
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(data)
+	if sum == j.hash {
+		return false, nil
+	}
+
+	data, fresh.migrated, err = j.migrate(data)
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, fresh); err != nil {
+		return false, err
+	}
+	if err := fresh.validate(); err != nil {
+		return false, err
+	}
+	j.hash = sum
+	return true, nil
+}
+
+// save can't write the config and the audit log together. The config comes
+// first, so a failure in between loses the record of a change rather than
+// recording one that never happened.
+func (j *jsonFile) save(s *Store, events []AuditEvent) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(j.filename, data, 0644); err != nil {
+		return err
+	}
+	j.hash = sha256.Sum256(data)
+	if len(events) > 0 {
+		if err := j.appendAudit(events); err != nil {
+			return &partialSaveError{fmt.Errorf("recording the change failed: %w", err)}
+		}
+	}
+	if err := j.writeBackup(data, s.BackupCount); err != nil {
+		return &partialSaveError{fmt.Errorf("backing it up failed: %w", err)}
+	}
+	return nil
+}
+
+// watch uses inotify where available and polling otherwise.
+func (j *jsonFile) watch(ctx context.Context, changed func()) {
+	if err := j.watchFile(ctx, changed); err != nil && ctx.Err() == nil {
+		j.pollFile(ctx, changed)
+	}
+}
+
+func (j *jsonFile) close() error {
+	return nil
+}
+
+// auditFile is where the audit log of the config is kept, e.g.
+// "wol-audit.jsonl" next to "wol.json", one event per line.
+func (j *jsonFile) auditFile() string {
+	prefix, _ := j.backupPrefix()
+	return filepath.Join(filepath.Dir(j.filename), prefix+"audit.jsonl")
+}
+
+func (j *jsonFile) loadAudit() ([]AuditEvent, error) {
+	f, err := os.Open(j.auditFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var e AuditEvent
+		// A line cut short by a crash is skipped rather than losing the log
+		if err := json.Unmarshal(scanner.Bytes(), &e); err == nil {
+			events = append(events, e)
+		}
+	}
+	return events, scanner.Err()
+}
+
+func (j *jsonFile) appendAudit(events []AuditEvent) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(j.auditFile(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// pruneAudit rewrites the audit log without the expired events.
+func (j *jsonFile) pruneAudit(cutoff time.Time) error {
+	events, err := j.loadAudit()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if e.Time.Before(cutoff) {
+			continue
+		}
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(j.auditFile(), buf.Bytes(), 0644)
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrDuplicateMAC is returned when a device would repeat a MAC, or use one
+// that another device already has.
+var ErrDuplicateMAC = errors.New("duplicate MAC address")
+
+// canonicalMAC returns mac in the form MACs are stored and compared in, lower
+// case and colon separated, or just lower case if it doesn't parse.
+func canonicalMAC(mac string) string {
+	if hw, err := net.ParseMAC(mac); err == nil {
+		return hw.String()
+	}
+	return strings.ToLower(mac)
+}
+
+// canonicalMACs rewrites the member MACs of devices in canonical form and
+// reports whether any changed.
+func canonicalMACs(devices []Device) bool {
+	changed := false
+	for i := range devices {
+		for j := range devices[i].SubDevices {
+			sub := &devices[i].SubDevices[j]
+			if mac := canonicalMAC(sub.MAC); mac != sub.MAC {
+				sub.MAC = mac
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// checkMACs returns an ErrDuplicateMAC error if d lists a MAC twice or uses
+// one of a device in devices other than devices[skip].
+func checkMACs(devices []Device, skip int, d Device) error {
+	seen := make(map[string]bool)
+	for _, sub := range d.SubDevices {
+		key := canonicalMAC(sub.MAC)
+		if seen[key] {
+			return fmt.Errorf("%w: %s is listed twice", ErrDuplicateMAC, sub.MAC)
+		}
+		seen[key] = true
+		for i, dev := range devices {
+			if i != skip && hasMAC(dev, sub.MAC) {
+				return fmt.Errorf("%w: %s already belongs to %q", ErrDuplicateMAC, sub.MAC, dev.Name)
+			}
+		}
+	}
+	return nil
+}
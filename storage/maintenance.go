@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrMaintenance is returned when waking a device that is in maintenance.
+var ErrMaintenance = errors.New("device is in maintenance")
+
+// Maintenance blocks waking a device, e.g. while it is being reimaged. It
+// ends at Until, or when it is turned off if Until is unset.
+type Maintenance struct {
+	Reason string     `json:"reason,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+	Since  time.Time  `json:"since"`
+	By     string     `json:"by,omitempty"`
+}
+
+// Active reports whether m is in effect at now. A nil m never is.
+func (m *Maintenance) Active(now time.Time) bool {
+	return m != nil && (m.Until == nil || now.Before(*m.Until))
+}
+
+// String describes the end and reason of m, e.g. "until 2024-05-01 18:00
+// (reimaging)".
+func (m *Maintenance) String() string {
+	var parts []string
+	if m.Until != nil {
+		parts = append(parts, "until "+m.Until.Local().Format("2006-01-02 15:04"))
+	}
+	if m.Reason != "" {
+		parts = append(parts, "("+m.Reason+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Err returns m as an ErrMaintenance error.
+func (m *Maintenance) Err() error {
+	if s := m.String(); s != "" {
+		return fmt.Errorf("%w %s", ErrMaintenance, s)
+	}
+	return ErrMaintenance
+}
+
+// InMaintenance reports whether waking d is currently blocked.
+func (d Device) InMaintenance() bool {
+	return d.Maintenance.Active(time.Now())
+}
+
+// SetMaintenance puts the device identified by key (ID or name) in
+// maintenance, or takes it out if m is nil.
+func (s *Store) SetMaintenance(by Actor, key string, m *Maintenance) (Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := s.indexOf(key)
+	if idx < 0 {
+		return Device{}, ErrDeviceNotFound
+	}
+	if m != nil {
+		if m.Until != nil && !m.Until.After(time.Now()) {
+			return Device{}, errors.New("maintenance end must be in the future")
+		}
+		m.Since = time.Now()
+		m.By = by.Name
+	}
+
+	d := s.Devices[idx]
+	d.Maintenance = m
+	s.Devices[idx] = d
+	return d, s.commit(by, "")
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrUnknownMember is returned when a group references a device that
+	// doesn't exist.
+	ErrUnknownMember = errors.New("unknown member device")
+	// ErrMemberCycle is returned when a group would contain itself, directly
+	// or through nested groups.
+	ErrMemberCycle = errors.New("group would contain itself")
+	// ErrInUse is returned when deleting a device would leave a group that
+	// references it without members, or deleting a network that members are
+	// on.
+	ErrInUse = errors.New("still in use")
+)
+
+// Member is a machine to wake or ping, together with the device whose
+// sub_devices list it.
+type Member struct {
+	SubDevice
+	DeviceID    string       `json:"device_id"`
+	DeviceName  string       `json:"device_name"`
+	Maintenance *Maintenance `json:"maintenance,omitempty"` // Of the device it belongs to
+	Protected   bool         `json:"protected,omitempty"`   // The device it belongs to is protected
+	Target      Target       `json:"target"`                // Only filled in by Store.Expand
+}
+
+// Expand returns the members of d followed by those of the devices it
+// references, recursively and in order. A machine reached through several
+// groups is listed once, and devices missing from devices are skipped.
+func Expand(devices []Device, d Device) []Member {
+	byID := make(map[string]Device, len(devices))
+	for _, dev := range devices {
+		byID[dev.ID] = dev
+	}
+
+	var members []Member
+	seenSub := make(map[string]bool)
+	seenDevice := make(map[string]bool)
+	var walk func(d Device)
+	walk = func(d Device) {
+		if d.ID != "" {
+			if seenDevice[d.ID] {
+				return
+			}
+			seenDevice[d.ID] = true
+		}
+		for _, sub := range d.SubDevices {
+			if sub.ID != "" && seenSub[sub.ID] {
+				continue
+			}
+			seenSub[sub.ID] = true
+			members = append(members, Member{SubDevice: sub, DeviceID: d.ID, DeviceName: d.Name, Maintenance: d.Maintenance, Protected: d.Protection != nil})
+		}
+		for _, id := range d.Members {
+			if dev, ok := byID[id]; ok {
+				walk(dev)
+			}
+		}
+	}
+	walk(d)
+	return members
+}
+
+// Expand returns every machine d covers, see the Expand function, with where
+// to send its magic packets worked out from its network.
+func (s *Store) Expand(d Device) []Member {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	members := Expand(s.Devices, d)
+	for i := range members {
+		members[i].Target = targetOf(s.Networks, members[i].SubDevice)
+	}
+	return members
+}
+
+// resolveMembers replaces the device names among d's members by IDs and
+// checks that every one exists, isn't d itself, and doesn't contain d. idx is
+// d's index in devices, or -1 for a new device.
+func resolveMembers(devices []Device, idx int, d *Device) error {
+	members := make([]string, 0, len(d.Members))
+	seen := make(map[string]bool)
+	for _, key := range d.Members {
+		i := indexIn(devices, key)
+		if i < 0 || devices[i].ID == "" {
+			return fmt.Errorf("%w: %s", ErrUnknownMember, key)
+		}
+		if i == idx {
+			return fmt.Errorf("%w: %q is listed as its own member", ErrMemberCycle, d.Name)
+		}
+		if id := devices[i].ID; !seen[id] {
+			seen[id] = true
+			members = append(members, id)
+		}
+	}
+	d.Members = members
+
+	if idx < 0 {
+		return nil // Nothing can reference a device that doesn't exist yet
+	}
+	working := make([]Device, len(devices))
+	copy(working, devices)
+	working[idx] = *d
+	working[idx].ID = devices[idx].ID
+	return checkCycles(working)
+}
+
+// checkCycles returns an ErrMemberCycle error if any group in devices
+// contains itself.
+func checkCycles(devices []Device) error {
+	byID := make(map[string]Device, len(devices))
+	for _, d := range devices {
+		byID[d.ID] = d
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(d Device) error
+	visit = func(d Device) error {
+		switch state[d.ID] {
+		case visiting:
+			return fmt.Errorf("%w: %s", ErrMemberCycle, strings.Join(append(path, d.Name), " → "))
+		case done:
+			return nil
+		}
+		state[d.ID] = visiting
+		path = append(path, d.Name)
+		for _, id := range d.Members {
+			if member, ok := byID[id]; ok {
+				if err := visit(member); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[d.ID] = done
+		return nil
+	}
+	for _, d := range devices {
+		if err := visit(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkMembers verifies the references of a freshly read config: every
+// member must exist and no group may contain itself.
+func checkMembers(devices []Device) error {
+	ids := make(map[string]bool, len(devices))
+	for _, d := range devices {
+		ids[d.ID] = true
+	}
+	for _, d := range devices {
+		for _, id := range d.Members {
+			if id == "" || !ids[id] {
+				return fmt.Errorf("device %q: %w: %s", d.Name, ErrUnknownMember, id)
+			}
+		}
+	}
+	return checkCycles(devices)
+}
+
+// dropMember removes id from the members of every group in devices. It fails
+// with ErrInUse if a group would be left with nothing to wake.
+func dropMember(devices []Device, id string) error {
+	for i := range devices {
+		d := &devices[i]
+		members := make([]string, 0, len(d.Members))
+		for _, m := range d.Members {
+			if m != id {
+				members = append(members, m)
+			}
+		}
+		if len(members) == len(d.Members) {
+			continue
+		}
+		if len(members) == 0 && len(d.SubDevices) == 0 {
+			return fmt.Errorf("%w: it is the only member of %q", ErrInUse, d.Name)
+		}
+		if len(members) == 0 {
+			members = nil
+		}
+		d.Members = members
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CurrentSchemaVersion is the config file format written by this build.
+// static/schema/wol.schema.json describes it.
+const CurrentSchemaVersion = 2
+
+// ErrNewerSchema is returned when the config file was written by a newer
+// version of the program.
+var ErrNewerSchema = errors.New("config schema version is newer than supported")
+
+// migration upgrades a decoded config file by one schema version.
+type migration struct {
+	description string
+	apply       func(cfg map[string]interface{}) error
+}
+
+// migrations[i] upgrades a file from schema version i to i+1. Files without
+// a schema_version are version 0.
+var migrations = []migration{
+	{"move single-device fields into sub_devices", migrateSubDevices},
+	{"assign device IDs", migrateIDs},
+}
+
+// migrate upgrades data to CurrentSchemaVersion, backing up the content as
+// it is before each step. It reports whether any step ran.
+func (j *jsonFile) migrate(data []byte) ([]byte, bool, error) {
+	var cfg map[string]interface{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, false, err
+	}
+
+	version := 0
+	if v, ok := cfg["schema_version"].(float64); ok {
+		version = int(v)
+	}
+	if version > CurrentSchemaVersion {
+		return nil, false, fmt.Errorf("%w (%d > %d)", ErrNewerSchema, version, CurrentSchemaVersion)
+	}
+	if version == CurrentSchemaVersion {
+		return data, false, nil
+	}
+
+	// backup_count, defaulted as by applyDefaults
+	count := 10
+	if v, ok := cfg["backup_count"].(float64); ok && v != 0 {
+		count = int(v)
+	}
+	for ; version < CurrentSchemaVersion; version++ {
+		if err := j.writeMigrationBackup(data, version, count); err != nil {
+			return nil, false, fmt.Errorf("backing up schema %d: %w", version, err)
+		}
+		m := migrations[version]
+		if err := m.apply(cfg); err != nil {
+			return nil, false, fmt.Errorf("migrating schema %d to %d (%s): %w", version, version+1, m.description, err)
+		}
+		cfg["schema_version"] = version + 1
+
+		var err error
+		if data, err = json.MarshalIndent(cfg, "", "  "); err != nil {
+			return nil, false, err
+		}
+	}
+	return data, true, nil
+}
+
+// migrationBackupPrefix is the start of the names of the backups taken before
+// upgrading from version, e.g. "wol.json.v0-20240102-150405.000.bak". They
+// are named differently from the rotating backups so those never crowd them
+// out.
+func (j *jsonFile) migrationBackupPrefix(version int) string {
+	return fmt.Sprintf("%s.v%d-", filepath.Base(j.filename), version)
+}
+
+// writeMigrationBackup keeps data, the content of the config file at schema
+// version, and prunes older backups of that version down to count. The
+// newest is kept even if backups are disabled.
+func (j *jsonFile) writeMigrationBackup(data []byte, version, count int) error {
+	if err := os.MkdirAll(j.backupDir(), 0755); err != nil {
+		return err
+	}
+	prefix := j.migrationBackupPrefix(version)
+	name := prefix + time.Now().Format(backupTimeFormat) + ".bak"
+	if err := writeFileAtomic(filepath.Join(j.backupDir(), name), data, 0644); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(j.backupDir())
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), prefix) && strings.HasSuffix(e.Name(), ".bak") {
+			backups = append(backups, e.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	for i := max(count, 1); i < len(backups); i++ {
+		os.Remove(filepath.Join(j.backupDir(), backups[i]))
+	}
+	return nil
+}
+
+// configDevices returns the decoded devices array, or nil if there is none.
+func configDevices(cfg map[string]interface{}) []interface{} {
+	devices, _ := cfg["devices"].([]interface{})
+	return devices
+}
+
+// migrateSubDevices turns devices that kept their MAC, IP, port and broadcast
+// address at the top level into single-member groups, which is how the web UI
+// has always edited them.
+func migrateSubDevices(cfg map[string]interface{}) error {
+	for _, raw := range configDevices(cfg) {
+		d, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("device is not an object: %v", raw)
+		}
+		subs, _ := d["sub_devices"].([]interface{})
+		if len(subs) == 0 {
+			if mac, _ := d["mac"].(string); mac != "" {
+				sub := map[string]interface{}{
+					"mac":          mac,
+					"ip":           d["ip"],
+					"port":         d["port"],
+					"broadcast_ip": d["broadcast_ip"],
+					"remark":       "",
+				}
+				for k, v := range sub {
+					if v == nil {
+						sub[k] = ""
+					}
+				}
+				if port, _ := sub["port"].(float64); port == 0 {
+					sub["port"] = 9
+				}
+				d["sub_devices"] = []interface{}{sub}
+			}
+		}
+		for _, k := range []string{"mac", "ip", "port", "broadcast_ip"} {
+			delete(d, k)
+		}
+	}
+	return nil
+}
+
+// migrateIDs gives every device and sub-device a stable ID.
+func migrateIDs(cfg map[string]interface{}) error {
+	data, err := json.Marshal(configDevices(cfg))
+	if err != nil {
+		return err
+	}
+	var devices []Device
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return err
+	}
+	if !assignIDs(devices, nil) {
+		return nil
+	}
+
+	// Hand the result back in decoded form for any later steps
+	if data, err = json.Marshal(devices); err != nil {
+		return err
+	}
+	var decoded []interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	cfg["devices"] = decoded
+	return nil
+}
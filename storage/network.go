@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Network is a named subnet that members can be placed on instead of each
+// repeating its broadcast address and port.
+type Network struct {
+	Name        string `json:"name"`
+	CIDR        string `json:"cidr,omitempty"`         // e.g. "192.168.50.0/24"; members' broadcast address is derived from it
+	BroadcastIP string `json:"broadcast_ip,omitempty"` // Overrides the address derived from CIDR
+	Interface   string `json:"interface,omitempty"`    // Send from this interface only, e.g. "eth1"
+	Relay       string `json:"relay,omitempty"`        // Host[:port] that forwards magic packets into the subnet
+	Port        int    `json:"port,omitempty"`         // Default 9
+}
+
+// ErrNetworkNotFound is returned for a network name that isn't configured.
+var ErrNetworkNotFound = errors.New("network not found")
+
+// Target is where the magic packets for a member are sent.
+type Target struct {
+	Address   string `json:"address,omitempty"` // Broadcast or relay address; "" for every interface
+	Port      int    `json:"port"`
+	Interface string `json:"interface,omitempty"`
+}
+
+// Validate checks the fields of a network.
+func (n *Network) Validate() error {
+	if strings.TrimSpace(n.Name) == "" {
+		return errors.New("network name is required")
+	}
+	if n.CIDR != "" {
+		ip, _, err := net.ParseCIDR(n.CIDR)
+		if err != nil || ip.To4() == nil {
+			return errors.New("invalid IPv4 CIDR: " + n.CIDR)
+		}
+	}
+	if n.BroadcastIP != "" && !isValidIP(n.BroadcastIP) {
+		return errors.New("invalid broadcast IP: " + n.BroadcastIP)
+	}
+	if n.Relay != "" {
+		host, port, err := splitRelay(n.Relay)
+		if err != nil || host == "" || !isValidHostOrIP(host) || port < 0 || port > 65535 {
+			return errors.New("invalid relay: " + n.Relay)
+		}
+	}
+	if n.Port < 0 || n.Port > 65535 {
+		return errors.New("invalid port number")
+	}
+	return nil
+}
+
+// splitRelay splits a relay into host and port, which is 0 if not given.
+func splitRelay(relay string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(relay)
+	if err != nil {
+		// No port; also covers bare IPv6 addresses
+		return strings.Trim(relay, "[]"), 0, nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 {
+		return "", 0, fmt.Errorf("invalid port %q", portStr)
+	}
+	return host, port, nil
+}
+
+// targetOf works out where the packets for sub go: its own broadcast address
+// wins, then its network's relay, the network's broadcast address, and the
+// broadcast address derived from the network's CIDR and sub's IP.
+func targetOf(networks []Network, sub SubDevice) Target {
+	t := Target{Address: sub.BroadcastIP, Port: sub.Port}
+	n, ok := findNetwork(networks, sub.Network)
+	if !ok {
+		if t.Port == 0 {
+			t.Port = 9
+		}
+		return t
+	}
+
+	t.Interface = n.Interface
+	if t.Port == 0 {
+		t.Port = n.Port
+	}
+	if t.Address == "" {
+		switch {
+		case n.Relay != "":
+			host, port, _ := splitRelay(n.Relay)
+			t.Address = host
+			if port != 0 {
+				t.Port = port
+			}
+		case n.BroadcastIP != "":
+			t.Address = n.BroadcastIP
+		case n.CIDR != "":
+			t.Address = deriveBroadcast(n.CIDR, sub.IP)
+		}
+	}
+	if t.Port == 0 {
+		t.Port = 9
+	}
+	return t
+}
+
+// deriveBroadcast returns the broadcast address of the subnet that ip, or the
+// address of cidr if ip isn't an IPv4 address, has with cidr's mask.
+func deriveBroadcast(cidr, ip string) string {
+	base, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return ""
+	}
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() != nil {
+		base = parsed
+	}
+	base = base.To4()
+	mask := ipnet.Mask
+	if len(mask) == net.IPv6len {
+		mask = mask[12:]
+	}
+	broadcast := make(net.IP, net.IPv4len)
+	for i := range broadcast {
+		broadcast[i] = base[i] | ^mask[i]
+	}
+	return broadcast.String()
+}
+
+func findNetwork(networks []Network, name string) (Network, bool) {
+	if name == "" {
+		return Network{}, false
+	}
+	for _, n := range networks {
+		if n.Name == name {
+			return n, true
+		}
+	}
+	return Network{}, false
+}
+
+// checkNetworks returns an error if a member of d is on a network that isn't
+// in networks.
+func checkNetworks(networks []Network, d Device) error {
+	for _, sub := range d.SubDevices {
+		if _, ok := findNetwork(networks, sub.Network); sub.Network != "" && !ok {
+			return fmt.Errorf("%w: %s", ErrNetworkNotFound, sub.Network)
+		}
+	}
+	return nil
+}
+
+// validateNetworks checks the networks of a freshly read config and the
+// devices' references to them.
+func (s *Store) validateNetworks() error {
+	names := make(map[string]bool)
+	for i := range s.Networks {
+		n := &s.Networks[i]
+		if err := n.Validate(); err != nil {
+			return fmt.Errorf("network %q: %w", n.Name, err)
+		}
+		if names[n.Name] {
+			return fmt.Errorf("network %q is defined twice", n.Name)
+		}
+		names[n.Name] = true
+	}
+	for _, d := range s.Devices {
+		if err := checkNetworks(s.Networks, d); err != nil {
+			return fmt.Errorf("device %q: %w", d.Name, err)
+		}
+	}
+	return nil
+}
+
+// Target returns where the magic packets for sub are sent.
+func (s *Store) Target(sub SubDevice) Target {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return targetOf(s.Networks, sub)
+}
+
+// GetNetworks returns a copy of the configured networks.
+func (s *Store) GetNetworks() []Network {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Network(nil), s.Networks...)
+}
+
+// GetNetwork looks a network up by name.
+func (s *Store) GetNetwork(name string) (Network, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return findNetwork(s.Networks, name)
+}
+
+// AddNetwork stores a new network.
+func (s *Store) AddNetwork(by Actor, n Network) (Network, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n.Name = strings.TrimSpace(n.Name)
+	if err := n.Validate(); err != nil {
+		return Network{}, err
+	}
+	if _, ok := findNetwork(s.Networks, n.Name); ok {
+		return Network{}, errors.New("network with this name already exists")
+	}
+	s.Networks = append(s.Networks, n)
+	return n, s.commit(by, "")
+}
+
+// UpdateNetwork replaces the network called name with n. Renaming it moves
+// the members on it along, including those of trashed devices.
+func (s *Store) UpdateNetwork(by Actor, name string, n Network) (Network, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n.Name = strings.TrimSpace(n.Name)
+	if err := n.Validate(); err != nil {
+		return Network{}, err
+	}
+	idx := -1
+	for i, existing := range s.Networks {
+		if existing.Name == name {
+			idx = i
+		} else if existing.Name == n.Name {
+			return Network{}, errors.New("network with this name already exists")
+		}
+	}
+	if idx < 0 {
+		return Network{}, ErrNetworkNotFound
+	}
+
+	networks := append([]Network(nil), s.Networks...)
+	networks[idx] = n
+	s.Networks = networks
+	if n.Name != name {
+		s.Devices = renameNetwork(s.Devices, name, n.Name)
+		s.Trash = renameTrashedNetwork(s.Trash, name, n.Name)
+	}
+	return n, s.commit(by, "")
+}
+
+// renameNetwork returns devices with the members on network from moved to
+// network to, copying only the devices that change.
+func renameNetwork(devices []Device, from, to string) []Device {
+	out := make([]Device, len(devices))
+	for i, d := range devices {
+		out[i] = networkRenamed(d, from, to)
+	}
+	return out
+}
+
+// renameTrashedNetwork is renameNetwork for the trash, so that trashed
+// devices can still be restored after a rename.
+func renameTrashedNetwork(trash []TrashedDevice, from, to string) []TrashedDevice {
+	if trash == nil {
+		return nil
+	}
+	out := make([]TrashedDevice, len(trash))
+	for i, t := range trash {
+		t.Device = networkRenamed(t.Device, from, to)
+		out[i] = t
+	}
+	return out
+}
+
+// networkRenamed returns d with its members on network from moved to network
+// to. The members are copied only if one changes.
+func networkRenamed(d Device, from, to string) Device {
+	copied := false
+	for j := range d.SubDevices {
+		if d.SubDevices[j].Network != from {
+			continue
+		}
+		if !copied {
+			d.SubDevices = append([]SubDevice(nil), d.SubDevices...)
+			copied = true
+		}
+		d.SubDevices[j].Network = to
+	}
+	return d
+}
+
+// DeleteNetwork removes the network called name. It fails with ErrInUse while
+// members are on it, including those of devices in the trash, which could
+// otherwise no longer be restored.
+func (s *Store) DeleteNetwork(by Actor, name string) (Network, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := -1
+	for i, n := range s.Networks {
+		if n.Name == name {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return Network{}, ErrNetworkNotFound
+	}
+	for _, d := range s.Devices {
+		for _, sub := range d.SubDevices {
+			if sub.Network == name {
+				return Network{}, fmt.Errorf("%w: %q has members on it", ErrInUse, d.Name)
+			}
+		}
+	}
+	cutoff := s.trashCutoff()
+	for _, t := range s.Trash {
+		if t.DeletedAt.Before(cutoff) {
+			continue
+		}
+		for _, sub := range t.SubDevices {
+			if sub.Network == name {
+				return Network{}, fmt.Errorf("%w: %q in the trash has members on it, purge it first", ErrInUse, t.Name)
+			}
+		}
+	}
+
+	n := s.Networks[idx]
+	networks := make([]Network, 0, len(s.Networks)-1)
+	networks = append(networks, s.Networks[:idx]...)
+	s.Networks = append(networks, s.Networks[idx+1:]...)
+	return n, s.commit(by, "")
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Protection levels, see Protection.
+const (
+	ProtectConfirm = "confirm"
+	ProtectPIN     = "pin"
+)
+
+var (
+	// ErrConfirmRequired is returned when waking a device protected by
+	// confirmation without confirming.
+	ErrConfirmRequired = errors.New("device is protected, confirm the wake")
+	// ErrPINRequired is returned when waking a device protected by a PIN
+	// without one.
+	ErrPINRequired = errors.New("device is protected, a PIN is required")
+	// ErrWrongPIN is returned when waking a device protected by a PIN with a
+	// different one.
+	ErrWrongPIN = errors.New("wrong PIN")
+)
+
+// pinIterations is the PBKDF2 work factor of stored PINs.
+const pinIterations = 100000
+
+// Protection guards a device against being woken by a stray click: waking it
+// needs an explicit confirmation, or its PIN.
+type Protection struct {
+	Level   string `json:"level"`              // "confirm" or "pin"
+	PINHash string `json:"pin_hash,omitempty"` // "<salt>:<PBKDF2-SHA256>", both hex
+}
+
+// WakeProof is what a caller gives to wake a protected device.
+type WakeProof struct {
+	Confirm bool   `json:"confirm,omitempty"`
+	PIN     string `json:"pin,omitempty"`
+}
+
+// WithoutSecrets returns d without its PIN hash, for anything shown to
+// clients: the hash would let them guess the PIN offline.
+func (d Device) WithoutSecrets() Device {
+	if d.Protection != nil {
+		d.Protection = &Protection{Level: d.Protection.Level}
+	}
+	return d
+}
+
+func (p *Protection) validate() error {
+	switch p.Level {
+	case ProtectConfirm:
+	case ProtectPIN:
+		if p.PINHash == "" {
+			return errors.New("protection: pin_hash is required for level pin")
+		}
+	default:
+		return fmt.Errorf("protection: unknown level %q", p.Level)
+	}
+	return nil
+}
+
+// Check returns nil if proof is enough to wake a device protected by p. A nil
+// p protects nothing.
+func (p *Protection) Check(proof WakeProof) error {
+	switch {
+	case p == nil:
+		return nil
+	case p.Level == ProtectPIN && proof.PIN == "":
+		return ErrPINRequired
+	case p.Level == ProtectPIN && !p.matchPIN(proof.PIN):
+		return ErrWrongPIN
+	case p.Level == ProtectConfirm && !proof.Confirm && proof.PIN == "":
+		return ErrConfirmRequired
+	}
+	return nil
+}
+
+func (p *Protection) matchPIN(pin string) bool {
+	saltHex, hashHex, ok := strings.Cut(p.PINHash, ":")
+	salt, err1 := hex.DecodeString(saltHex)
+	want, err2 := hex.DecodeString(hashHex)
+	if !ok || err1 != nil || err2 != nil {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, pin, salt, pinIterations, len(want))
+	return err == nil && subtle.ConstantTimeCompare(got, want) == 1
+}
+
+func hashPIN(pin string) (string, error) {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	hash, err := pbkdf2.Key(sha256.New, pin, salt, pinIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt) + ":" + hex.EncodeToString(hash), nil
+}
+
+// SetProtection sets the protection level of the device identified by key
+// (ID or name): "" for none, ProtectConfirm or ProtectPIN. pin is required
+// for ProtectPIN, except to keep the current PIN of a device that already has
+// one.
+func (s *Store) SetProtection(by Actor, key, level, pin string) (Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := s.indexOf(key)
+	if idx < 0 {
+		return Device{}, ErrDeviceNotFound
+	}
+	d := s.Devices[idx]
+
+	var p *Protection
+	switch level {
+	case "":
+	case ProtectConfirm:
+		p = &Protection{Level: level}
+	case ProtectPIN:
+		p = &Protection{Level: level}
+		switch {
+		case pin != "":
+			if len(pin) < 4 {
+				return Device{}, errors.New("PIN must be at least 4 characters")
+			}
+			hash, err := hashPIN(pin)
+			if err != nil {
+				return Device{}, err
+			}
+			p.PINHash = hash
+		case d.Protection != nil && d.Protection.PINHash != "":
+			p.PINHash = d.Protection.PINHash
+		default:
+			return Device{}, errors.New("PIN is required")
+		}
+	default:
+		return Device{}, fmt.Errorf("unknown protection level %q, use confirm or pin", level)
+	}
+
+	d.Protection = p
+	s.Devices[idx] = d
+	return d, s.commit(by, "")
+}
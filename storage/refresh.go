@@ -0,0 +1,57 @@
+package storage
+
+import "net"
+
+// IPChange is a member whose IP was, or would be, refreshed.
+type IPChange struct {
+	DeviceID string `json:"device_id"`
+	Device   string `json:"device"`
+	MAC      string `json:"mac"`
+	OldIP    string `json:"old_ip"`
+	NewIP    string `json:"new_ip"`
+}
+
+// RefreshIPs sets the IP of every member whose MAC appears in leases to the
+// IP found there, and saves once. Members that are pinged by host name are
+// left alone, and no devices are added. With dryRun the config is left alone
+// and only the changes are returned.
+func (s *Store) RefreshIPs(by Actor, leases []Device, dryRun bool) ([]IPChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ips := make(map[string]string)
+	for _, d := range leases {
+		d.normalize()
+		for _, sub := range d.SubDevices {
+			if net.ParseIP(sub.IP) != nil {
+				ips[canonicalMAC(sub.MAC)] = sub.IP
+			}
+		}
+	}
+
+	changes := []IPChange{}
+	working := make([]Device, len(s.Devices))
+	copy(working, s.Devices)
+	for i := range working {
+		d := &working[i]
+		copied := false
+		for j, sub := range d.SubDevices {
+			ip, ok := ips[canonicalMAC(sub.MAC)]
+			if !ok || ip == sub.IP || (sub.IP != "" && net.ParseIP(sub.IP) == nil) {
+				continue
+			}
+			if !copied {
+				d.SubDevices = append([]SubDevice(nil), d.SubDevices...)
+				copied = true
+			}
+			d.SubDevices[j].IP = ip
+			changes = append(changes, IPChange{DeviceID: d.ID, Device: d.Name, MAC: sub.MAC, OldIP: sub.IP, NewIP: ip})
+		}
+	}
+
+	if dryRun || len(changes) == 0 {
+		return changes, nil
+	}
+	s.Devices = working
+	return changes, s.commit(by, "IP refresh")
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+)
+
+// applyDefaults fills in the options that are unset.
+func (st *Settings) applyDefaults() {
+	if st.Port == 0 {
+		st.Port = 8888
+	}
+	if st.LogDir == "" {
+		st.LogDir = "./logs"
+	}
+	if st.LogRetentionDays == 0 {
+		st.LogRetentionDays = 3
+	}
+	if st.BackupCount == 0 {
+		st.BackupCount = 10
+	}
+	if st.AuditRetentionDays == 0 {
+		st.AuditRetentionDays = 90
+	}
+	if st.TrashRetentionDays == 0 {
+		st.TrashRetentionDays = 30
+	}
+	if st.WakeCooldown == 0 {
+		st.WakeCooldown = 10
+	}
+	if st.WakeRateLimit == 0 {
+		st.WakeRateLimit = 30
+	}
+	if st.PingRateLimit == 0 {
+		st.PingRateLimit = 1200
+	}
+}
+
+// Validate checks the options. Zero values are allowed where a default
+// applies.
+func (st *Settings) Validate() error {
+	if st.Port < 0 || st.Port > 65535 {
+		return errors.New("invalid port number")
+	}
+	if st.LogRetentionDays < 0 {
+		return errors.New("log_retention_days must be at least 1")
+	}
+	if st.BackupCount < -1 {
+		return errors.New("backup_count must be -1 (no backups) or more")
+	}
+	if st.AuditRetentionDays < 0 {
+		return errors.New("audit_retention_days must be at least 1")
+	}
+	if st.TrashRetentionDays < 0 {
+		return errors.New("trash_retention_days must be at least 1")
+	}
+	if st.WakeCooldown < -1 {
+		return errors.New("wake_cooldown_seconds must be -1 (none) or more")
+	}
+	if st.WakeRateLimit < -1 || st.PingRateLimit < -1 {
+		return errors.New("rate limits must be -1 (unlimited) or more")
+	}
+	if strings.ContainsAny(st.APIToken, " \t\r\n") {
+		return errors.New("api_token must not contain whitespace")
+	}
+	for _, src := range st.LeaseSync {
+		if strings.TrimSpace(src.File) == "" {
+			return errors.New("lease_sync: file is required")
+		}
+		if src.IntervalMinutes < 0 {
+			return errors.New("lease_sync: interval_minutes must be positive")
+		}
+	}
+	return nil
+}
+
+// GetSettings returns a copy of the global options.
+func (s *Store) GetSettings() Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	st := s.Settings
+	st.LeaseSync = append([]LeaseSource(nil), st.LeaseSync...)
+	return st
+}
+
+// UpdateSettings replaces the global options, filling in defaults for unset
+// ones, and returns them as saved.
+func (s *Store) UpdateSettings(by Actor, st Settings) (Settings, error) {
+	if err := st.Validate(); err != nil {
+		return Settings{}, err
+	}
+	st.applyDefaults()
+	st.LeaseSync = append([]LeaseSource(nil), st.LeaseSync...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Settings = st
+	return st, s.commit(by, "")
+}
@@ -1,211 +1,497 @@
 package storage
 
 import (
-	"encoding/json"
 	"errors"
+	"fmt"
 	"net"
 	"os"
 	"regexp"
+	"strings"
 	"sync"
+	"time"
 )
 
+// ErrDeviceNotFound is returned for a device ID or name that doesn't exist.
+var ErrDeviceNotFound = errors.New("device not found")
+
 type SubDevice struct {
+	ID          string `json:"id"`
 	MAC         string `json:"mac"`
 	IP          string `json:"ip"`
 	Port        int    `json:"port"`
 	BroadcastIP string `json:"broadcast_ip"`
 	Remark      string `json:"remark"`
+	Network     string `json:"network,omitempty"` // Name of a network whose broadcast address and port apply unless set here
 }
 
 type Device struct {
-	Name        string      `json:"name"`
-	MAC         string      `json:"mac,omitempty"`
-	IP          string      `json:"ip,omitempty"`
-	Port        int         `json:"port,omitempty"`
-	BroadcastIP string      `json:"broadcast_ip,omitempty"`
-	SubDevices  []SubDevice `json:"sub_devices,omitempty"`
-	PingMode    string      `json:"ping_mode,omitempty"` // "any" or "all"
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	MAC         string       `json:"mac,omitempty"`
+	IP          string       `json:"ip,omitempty"`
+	Port        int          `json:"port,omitempty"`
+	BroadcastIP string       `json:"broadcast_ip,omitempty"`
+	SubDevices  []SubDevice  `json:"sub_devices,omitempty"`
+	PingMode    string       `json:"ping_mode,omitempty"` // "any" or "all"
+	Tags        []string     `json:"tags,omitempty"`
+	Folder      string       `json:"folder,omitempty"`                // Levels separated by "/", e.g. "Office/Floor 2"
+	Members     []string     `json:"members,omitempty"`               // IDs of other devices this group includes
+	Maintenance *Maintenance `json:"maintenance,omitempty"`           // Blocks waking while active
+	Protection  *Protection  `json:"protection,omitempty"`            // Waking needs a confirmation or PIN
+	Cooldown    int          `json:"wake_cooldown_seconds,omitempty"` // Overrides the setting; -1 disables it
 }
 
 type Store struct {
-	mu               sync.RWMutex
-	filename         string
-	Port             int      `json:"port"`
-	LogDir           string   `json:"log_dir"`
-	LogRetentionDays int      `json:"log_retention_days"`
-	Devices          []Device `json:"devices"`
+	mu            sync.RWMutex
+	backend       backend
+	recoveredFrom string
+	importedFrom  string
+	subMu         sync.Mutex
+	subscribers   map[chan struct{}]struct{}
+	migrated      bool         // Loaded file was upgraded and needs saving
+	saved         snapshot     // Config as of the last recorded change
+	audit         []AuditEvent // Recorded changes, oldest first
+	lastEventID   int64
+	Schema        string          `json:"$schema,omitempty"` // Lets editors validate the file
+	SchemaVersion int             `json:"schema_version"`
+	Settings                      // Global options, inline in the file
+	Networks      []Network       `json:"networks,omitempty"`
+	Devices       []Device        `json:"devices"`
+	Trash         []TrashedDevice `json:"trash,omitempty"` // Deleted devices that can still be restored
+}
+
+// Settings are the global options of the config.
+type Settings struct {
+	Port               int           `json:"port"`
+	LogDir             string        `json:"log_dir"`
+	LogRetentionDays   int           `json:"log_retention_days"`
+	BackupCount        int           `json:"backup_count"`                 // Backups of the config file to keep; -1 disables them
+	AuditRetentionDays int           `json:"audit_retention_days"`         // How long recorded changes can be reverted
+	TrashRetentionDays int           `json:"trash_retention_days"`         // How long deleted devices can be restored
+	WakeCooldown       int           `json:"wake_cooldown_seconds"`        // Minimum time between wakes of a device; -1 disables it
+	WakeRateLimit      int           `json:"wake_rate_limit"`              // Wake requests per minute and client; -1 is unlimited
+	PingRateLimit      int           `json:"ping_rate_limit"`              // Ping requests per minute and client; -1 is unlimited
+	DisableAdHocWake   bool          `json:"disable_adhoc_wake,omitempty"` // Refuses waking MACs that aren't saved devices
+	APIToken           string        `json:"api_token,omitempty"`          // Required as a Bearer token on /api/ when set
+	LeaseSync          []LeaseSource `json:"lease_sync,omitempty"`         // DHCP files to refresh device IPs from
+}
+
+// LeaseSource is a DHCP lease or reservation file that device IPs are
+// periodically refreshed from, matched by MAC.
+type LeaseSource struct {
+	File            string `json:"file"`
+	Format          string `json:"format,omitempty"`           // Guessed from the file name if empty
+	IntervalMinutes int    `json:"interval_minutes,omitempty"` // Default 5
 }
 
+// NewStore opens the config at filename. Files ending in .db or .bolt use
+// the embedded database; anything else is a JSON file such as wol.json.
 func NewStore(filename string) (*Store, error) {
 	s := &Store{
-		filename:         filename,
-		Port:             8888, // Default port
-		LogDir:           "./logs",
-		LogRetentionDays: 3,
-		Devices:          []Device{},
+		SchemaVersion: CurrentSchemaVersion,
+		Settings: Settings{
+			Port:               8888, // Default port
+			LogDir:             "./logs",
+			LogRetentionDays:   3,
+			BackupCount:        10,
+			AuditRetentionDays: 90,
+			TrashRetentionDays: 30,
+			WakeCooldown:       10,
+			WakeRateLimit:      30,
+			PingRateLimit:      1200,
+		},
+		Devices: []Device{},
 	}
-	if err := s.Load(); err != nil {
-		// If file doesn't exist, create it with defaults
-		if os.IsNotExist(err) {
-			if err := s.Save(); err != nil {
-				return nil, err
-			}
-		} else {
+	if isDatabase(filename) {
+		db, err := openDatabase(filename)
+		if err != nil {
+			return nil, err
+		}
+		s.backend = db
+		if err := s.openDatabase(filename); err != nil {
+			db.close()
+			return nil, err
+		}
+	} else {
+		s.backend = &jsonFile{filename: filename}
+		if err := s.openFile(); err != nil {
 			return nil, err
 		}
 	}
-	// Ensure defaults if loaded file didn't have them
-	if s.Port == 0 {
-		s.Port = 8888
+
+	// Upgraded files are saved right away; IDs are also repaired in case of
+	// hand-edited duplicates, and MACs put in canonical form.
+	idsAssigned := assignIDs(s.Devices, nil)
+	if canonicalMACs(s.Devices) || idsAssigned || s.migrated {
+		if err := s.Save(); err != nil {
+			s.Close()
+			return nil, err
+		}
 	}
-	if s.LogDir == "" {
-		s.LogDir = "./logs"
+	s.applyDefaults()
+	if len(s.expireTrash()) > 0 {
+		if err := s.Save(); err != nil {
+			s.Close()
+			return nil, err
+		}
 	}
-	if s.LogRetentionDays == 0 {
-		s.LogRetentionDays = 3
+	if err := s.loadAudit(); err != nil {
+		s.Close()
+		return nil, err
 	}
 	return s, nil
 }
 
+// openFile loads the JSON config file, creating it if it doesn't exist.
+func (s *Store) openFile() error {
+	err := s.Load()
+	switch {
+	case err == nil:
+		return nil
+	case os.IsNotExist(err):
+		// If file doesn't exist, create it with defaults
+		return s.Save()
+	case errors.Is(err, ErrNewerSchema):
+		// Written by a newer version; an old backup would lose its changes
+		return err
+	}
+
+	// A corrupt file is replaced by the newest good backup
+	backup, restoreErr := s.backend.(*jsonFile).restoreBackup(s)
+	if restoreErr != nil {
+		return fmt.Errorf("%v (restoring backup: %v)", err, restoreErr)
+	}
+	s.recoveredFrom = backup
+	return nil
+}
+
+// applyDefaults fills in settings that the loaded file didn't have.
+func (s *Store) applyDefaults() {
+	s.Settings.applyDefaults()
+}
+
+// ImportedFrom returns the JSON config file a new database was filled from,
+// or "" if there was none.
+func (s *Store) ImportedFrom() string {
+	return s.importedFrom
+}
+
+// RecoveredFrom returns the backup the config was restored from because the
+// config file was corrupt at startup, or "" if it loaded normally.
+func (s *Store) RecoveredFrom() string {
+	return s.recoveredFrom
+}
+
+// copyConfig takes over everything that is persisted in the config file.
+func (s *Store) copyConfig(from *Store) {
+	s.Schema = from.Schema
+	s.SchemaVersion = from.SchemaVersion
+	s.Settings = from.Settings
+	s.Networks = from.Networks
+	s.Devices = from.Devices
+	s.Trash = from.Trash
+}
+
 func (s *Store) Load() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.backend.load(s)
+}
 
-	data, err := os.ReadFile(s.filename)
-	if err != nil {
-		return err
+// Reload re-reads the config. The current state is only replaced if it
+// parses and every device in it is valid.
+func (s *Store) Reload() error {
+	_, err := s.reload()
+	return err
+}
+
+// reload is Reload that skips a config identical to what the store last read
+// or wrote itself, and reports whether anything was applied.
+func (s *Store) reload() (bool, error) {
+	// Hold the lock while reading so a concurrent save can't be overwritten
+	// by the content it replaced.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fresh := &Store{}
+	if changed, err := s.backend.reload(fresh); !changed {
+		return false, err
 	}
 
-	return json.Unmarshal(data, s)
+	idsAssigned := assignIDs(fresh.Devices, s.Devices)
+	macsChanged := canonicalMACs(fresh.Devices)
+	s.copyConfig(fresh)
+	recordErr := s.catchUpAudit()
+	if recordErr == nil {
+		recordErr = s.record(FileActor, "edited on disk")
+	}
+	if idsAssigned || macsChanged || fresh.migrated {
+		if err := s.saveInternal(); err != nil {
+			return true, err
+		}
+	} else {
+		s.notify()
+	}
+	if recordErr != nil {
+		return true, fmt.Errorf("config reloaded, but recording the change failed: %w", recordErr)
+	}
+	return true, nil
 }
 
-func (s *Store) saveInternal() error {
-	data, err := json.MarshalIndent(s, "", "  ")
-	if err != nil {
+// validate fills in defaults and checks every device of a freshly read
+// config.
+func (s *Store) validate() error {
+	if err := s.Settings.Validate(); err != nil {
 		return err
 	}
-	return os.WriteFile(s.filename, data, 0644)
+	s.applyDefaults()
+	if s.Devices == nil {
+		s.Devices = []Device{}
+	}
+	for _, d := range s.Devices {
+		if err := d.Validate(); err != nil {
+			return fmt.Errorf("device %q: %w", d.Name, err)
+		}
+	}
+	if err := s.validateNetworks(); err != nil {
+		return err
+	}
+	return checkMembers(s.Devices)
+}
+
+// Close releases the backend. The store must not be used afterwards.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.backend.close()
+}
+
+// Subscribe returns a channel that receives a value whenever the config
+// changes, whether through the store or by a reload from disk. Notifications
+// are coalesced; call cancel to unsubscribe.
+func (s *Store) Subscribe() (ch <-chan struct{}, cancel func()) {
+	c := make(chan struct{}, 1)
+	s.subMu.Lock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[chan struct{}]struct{})
+	}
+	s.subscribers[c] = struct{}{}
+	s.subMu.Unlock()
+
+	return c, func() {
+		s.subMu.Lock()
+		delete(s.subscribers, c)
+		s.subMu.Unlock()
+	}
+}
+
+func (s *Store) notify() {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	for c := range s.subscribers {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *Store) saveInternal() error {
+	err := s.backend.save(s, nil)
+	s.notify()
+	return err
 }
 
 func (s *Store) Save() error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.saveInternal()
 }
 
-func (s *Store) AddDevice(d Device) error {
+// AddDevice stores a new device and returns it with its generated IDs. Like
+// every change, it is recorded in the audit log as made by by.
+func (s *Store) AddDevice(by Actor, d Device) (Device, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	d.normalize()
 	if err := d.Validate(); err != nil {
-		return err
-	}
-
-	// Clear top-level fields if SubDevices is present to avoid duplication
-	if len(d.SubDevices) > 0 {
-		d.MAC = ""
-		d.IP = ""
-		d.Port = 0
-		d.BroadcastIP = ""
+		return Device{}, err
 	}
 
 	for _, dev := range s.Devices {
 		if dev.Name == d.Name {
-			return errors.New("device with this name already exists")
+			return Device{}, errors.New("device with this name already exists")
 		}
 	}
+	if err := checkMACs(s.Devices, -1, d); err != nil {
+		return Device{}, err
+	}
+	if err := checkNetworks(s.Networks, d); err != nil {
+		return Device{}, err
+	}
+	if err := resolveMembers(s.Devices, -1, &d); err != nil {
+		return Device{}, err
+	}
+
+	d.ID = newID()
+	d.SubDevices = append([]SubDevice(nil), d.SubDevices...)
+	for i := range d.SubDevices {
+		d.SubDevices[i].ID = newID()
+	}
 
 	s.Devices = append(s.Devices, d)
-	return s.saveInternal()
+	return d, s.commit(by, "")
 }
 
-func (s *Store) ReorderDevices(names []string) error {
+// indexOf finds a device by ID, or by name for clients that predate IDs.
+func (s *Store) indexOf(key string) int {
+	return indexIn(s.Devices, key)
+}
+
+func indexIn(devices []Device, key string) int {
+	for i, d := range devices {
+		if d.ID == key {
+			return i
+		}
+	}
+	for i, d := range devices {
+		if d.Name == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// ReorderDevices sets the order of all devices, given as IDs or names.
+func (s *Store) ReorderDevices(by Actor, keys []string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if len(names) != len(s.Devices) {
+	if len(keys) != len(s.Devices) {
 		return errors.New("device count mismatch")
 	}
 
-	deviceMap := make(map[string]Device)
-	for _, d := range s.Devices {
-		deviceMap[d.Name] = d
-	}
-
 	newDevices := make([]Device, 0, len(s.Devices))
-	for _, name := range names {
-		if d, ok := deviceMap[name]; ok {
-			newDevices = append(newDevices, d)
-		} else {
-			return errors.New("device not found: " + name)
+	used := make(map[int]bool)
+	for _, key := range keys {
+		i := s.indexOf(key)
+		if i < 0 {
+			return errors.New("device not found: " + key)
+		}
+		if used[i] {
+			return errors.New("device listed twice: " + key)
 		}
+		used[i] = true
+		newDevices = append(newDevices, s.Devices[i])
 	}
 
 	s.Devices = newDevices
-	return s.saveInternal()
+	return s.commit(by, "")
 }
 
-func (s *Store) UpdateDevice(oldName string, d Device) error {
+// UpdateDevice replaces the device identified by key (ID or name) with d.
+// The device keeps its ID and maintenance; sub-devices keep their IDs if d
+// carries them.
+func (s *Store) UpdateDevice(by Actor, key string, d Device) (Device, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	d.normalize()
 	if err := d.Validate(); err != nil {
-		return err
+		return Device{}, err
 	}
 
-	// Clear top-level fields if SubDevices is present to avoid duplication
-	if len(d.SubDevices) > 0 {
-		d.MAC = ""
-		d.IP = ""
-		d.Port = 0
-		d.BroadcastIP = ""
+	idx := s.indexOf(key)
+	if idx < 0 {
+		return Device{}, ErrDeviceNotFound
 	}
+	old := s.Devices[idx]
 
 	// If name is changing, check for conflict
-	if oldName != d.Name {
-		for _, dev := range s.Devices {
-			if dev.Name == d.Name {
-				return errors.New("device with this name already exists")
-			}
+	for i, dev := range s.Devices {
+		if i != idx && dev.Name == d.Name {
+			return Device{}, errors.New("device with this name already exists")
 		}
 	}
+	if err := checkMACs(s.Devices, idx, d); err != nil {
+		return Device{}, err
+	}
+	if err := checkNetworks(s.Networks, d); err != nil {
+		return Device{}, err
+	}
+	if err := resolveMembers(s.Devices, idx, &d); err != nil {
+		return Device{}, err
+	}
 
-	for i, dev := range s.Devices {
-		if dev.Name == oldName {
-			s.Devices[i] = d
-			return s.saveInternal()
+	d.ID = old.ID
+	d.Maintenance = old.Maintenance
+	d.Protection = old.Protection
+	known := make(map[string]bool)
+	for _, sub := range old.SubDevices {
+		known[sub.ID] = true
+	}
+	d.SubDevices = append([]SubDevice(nil), d.SubDevices...)
+	for i := range d.SubDevices {
+		sub := &d.SubDevices[i]
+		if !known[sub.ID] {
+			sub.ID = newID()
 		}
+		delete(known, sub.ID) // A repeated ID gets a fresh one
 	}
-	return errors.New("device not found")
+
+	s.Devices[idx] = d
+	return d, s.commit(by, "")
 }
 
-func (s *Store) DeleteDevice(name string) error {
+// DeleteDevice moves the device identified by key (ID or name) to the trash
+// and returns it. Groups that reference it lose it as a member. Devices that
+// have been in the trash for longer than trash_retention_days are purged.
+func (s *Store) DeleteDevice(by Actor, key string) (Device, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	newDevices := []Device{}
-	found := false
-	for _, d := range s.Devices {
-		if d.Name != name {
-			newDevices = append(newDevices, d)
-		} else {
-			found = true
-		}
-	}
-	
-	if !found {
-		return errors.New("device not found")
+	idx := s.indexOf(key)
+	if idx < 0 {
+		return Device{}, ErrDeviceNotFound
 	}
+	d := s.Devices[idx]
 
+	newDevices := make([]Device, 0, len(s.Devices)-1)
+	newDevices = append(newDevices, s.Devices[:idx]...)
+	newDevices = append(newDevices, s.Devices[idx+1:]...)
+	if err := dropMember(newDevices, d.ID); err != nil {
+		return Device{}, err
+	}
 	s.Devices = newDevices
-	return s.saveInternal()
+	s.Trash = append(s.Trash, TrashedDevice{Device: d, DeletedAt: time.Now(), DeletedBy: by.Name})
+	s.expireTrash()
+	return d, s.commit(by, "moved to trash")
 }
 
-func (s *Store) GetDevice(name string) (Device, bool) {
+// GetDevice looks a device up by ID or name.
+func (s *Store) GetDevice(key string) (Device, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	for _, d := range s.Devices {
-		if d.Name == name {
+	if i := s.indexOf(key); i >= 0 {
+		return s.Devices[i], true
+	}
+	return Device{}, false
+}
+
+// FindByMAC returns the first device with a member using mac, compared
+// regardless of case and separators.
+func (s *Store) FindByMAC(mac string) (Device, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return FindByMAC(s.Devices, mac)
+}
+
+// FindByMAC is Store.FindByMAC for a device list, such as one returned by
+// the API.
+func FindByMAC(devices []Device, mac string) (Device, bool) {
+	for _, d := range devices {
+		if hasMAC(d, mac) {
 			return d, true
 		}
 	}
@@ -227,6 +513,18 @@ func (s *Store) GetPort() int {
 	return s.Port
 }
 
+func (s *Store) GetAPIToken() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.APIToken
+}
+
+func (s *Store) GetLeaseSync() []LeaseSource {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]LeaseSource(nil), s.LeaseSync...)
+}
+
 func isValidMAC(mac string) bool {
 	_, err := net.ParseMAC(mac)
 	return err == nil
@@ -262,7 +560,11 @@ func (sd *SubDevice) Validate() error {
 	if !isValidHostOrIP(sd.IP) {
 		return errors.New("invalid IP or Hostname: " + sd.IP)
 	}
-	if sd.Port < 1 || sd.Port > 65535 {
+	minPort := 1
+	if sd.Network != "" {
+		minPort = 0 // The network's port applies
+	}
+	if sd.Port < minPort || sd.Port > 65535 {
 		return errors.New("invalid port number")
 	}
 	if sd.BroadcastIP != "" && !isValidIP(sd.BroadcastIP) {
@@ -271,17 +573,69 @@ func (sd *SubDevice) Validate() error {
 	return nil
 }
 
+// normalize converts a device sent in the pre-group format, with MAC, IP,
+// port and broadcast address at the top level, into a single-member group,
+// puts its MACs in canonical form and tidies its tags, folder and member
+// list.
+func (d *Device) normalize() {
+	if len(d.SubDevices) == 0 && d.MAC != "" {
+		port := d.Port
+		if port == 0 {
+			port = 9
+		}
+		d.SubDevices = []SubDevice{{
+			MAC:         d.MAC,
+			IP:          d.IP,
+			Port:        port,
+			BroadcastIP: d.BroadcastIP,
+		}}
+	}
+	d.MAC = ""
+	d.IP = ""
+	d.Port = 0
+	d.BroadcastIP = ""
+
+	d.SubDevices = append([]SubDevice(nil), d.SubDevices...)
+	for i := range d.SubDevices {
+		d.SubDevices[i].MAC = canonicalMAC(d.SubDevices[i].MAC)
+		d.SubDevices[i].Network = strings.TrimSpace(d.SubDevices[i].Network)
+	}
+	d.Tags = cleanTags(d.Tags)
+	d.Folder = cleanFolder(d.Folder)
+
+	var members []string
+	for _, m := range d.Members {
+		if m = strings.TrimSpace(m); m != "" {
+			members = append(members, m)
+		}
+	}
+	d.Members = members
+}
+
 func (d *Device) Validate() error {
 	if d.Name == "" {
 		return errors.New("device name is required")
 	}
+	for _, tag := range d.Tags {
+		if strings.Contains(tag, ",") {
+			return fmt.Errorf("tag %q must not contain a comma", tag)
+		}
+	}
+	if d.Cooldown < -1 {
+		return errors.New("wake_cooldown_seconds must be -1 (none) or more")
+	}
+	if d.Protection != nil {
+		if err := d.Protection.validate(); err != nil {
+			return err
+		}
+	}
 	if len(d.SubDevices) > 0 {
 		for _, sd := range d.SubDevices {
 			if err := sd.Validate(); err != nil {
 				return err
 			}
 		}
-	} else {
+	} else if len(d.Members) == 0 {
 		// Fallback for single device structure if used directly
 		sd := SubDevice{
 			MAC:         d.MAC,
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// ErrNotInTrash is returned for a device that isn't in the trash.
+var ErrNotInTrash = errors.New("device not in trash")
+
+// TrashedDevice is a deleted device kept for trash_retention_days so it can
+// be restored. Trashed devices can't be woken or pinged.
+type TrashedDevice struct {
+	Device
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by,omitempty"`
+}
+
+// trashCutoff is when devices deleted before have expired.
+func (s *Store) trashCutoff() time.Time {
+	return time.Now().AddDate(0, 0, -s.TrashRetentionDays)
+}
+
+// trashIndex finds a trashed device by ID, or the most recently deleted one
+// with the given name. Expired devices that are still there are skipped.
+func (s *Store) trashIndex(key string) int {
+	cutoff := s.trashCutoff()
+	for i, t := range s.Trash {
+		if t.ID == key && !t.DeletedAt.Before(cutoff) {
+			return i
+		}
+	}
+	for i := len(s.Trash) - 1; i >= 0; i-- {
+		if t := s.Trash[i]; t.Name == key && !t.DeletedAt.Before(cutoff) {
+			return i
+		}
+	}
+	return -1
+}
+
+// expireTrash drops devices that have been in the trash for longer than
+// trash_retention_days, and returns them. The lock must be held.
+func (s *Store) expireTrash() []Device {
+	cutoff := s.trashCutoff()
+	var expired []Device
+	s.Trash = slices.DeleteFunc(slices.Clone(s.Trash), func(t TrashedDevice) bool {
+		if t.DeletedAt.Before(cutoff) {
+			expired = append(expired, t.Device)
+			return true
+		}
+		return false
+	})
+	if len(s.Trash) == 0 {
+		s.Trash = nil
+	}
+	return expired
+}
+
+// ExpireTrash drops devices that have been in the trash for longer than
+// trash_retention_days, saves the config if there were any, and returns
+// them.
+func (s *Store) ExpireTrash() ([]Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expired := s.expireTrash()
+	if len(expired) == 0 {
+		return nil, nil
+	}
+	return expired, s.saveInternal()
+}
+
+// GetTrash returns the trashed devices, most recently deleted first. Those
+// that have expired since the trash was last cleaned up are left out.
+func (s *Store) GetTrash() []TrashedDevice {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cutoff := s.trashCutoff()
+	trash := make([]TrashedDevice, 0, len(s.Trash))
+	for i := len(s.Trash) - 1; i >= 0; i-- {
+		if !s.Trash[i].DeletedAt.Before(cutoff) {
+			trash = append(trash, s.Trash[i])
+		}
+	}
+	return trash
+}
+
+// RestoreDevice moves a device from the trash back to the end of the device
+// list. Groups it was a member of don't get it back. It fails if its name or
+// a MAC has been taken since, or its network is gone.
+func (s *Store) RestoreDevice(by Actor, key string) (Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.trashIndex(key)
+	if i < 0 {
+		return Device{}, ErrNotInTrash
+	}
+	d := s.Trash[i].Device
+
+	for _, dev := range s.Devices {
+		if dev.Name == d.Name {
+			return Device{}, fmt.Errorf("device with name %q already exists, rename it first", d.Name)
+		}
+	}
+	if err := checkMACs(s.Devices, -1, d); err != nil {
+		return Device{}, err
+	}
+	if err := checkNetworks(s.Networks, d); err != nil {
+		return Device{}, err
+	}
+
+	// Members deleted in the meantime are dropped
+	members := slices.DeleteFunc(slices.Clone(d.Members), func(id string) bool {
+		return indexIn(s.Devices, id) < 0
+	})
+	if len(members) == 0 && len(d.SubDevices) == 0 {
+		return Device{}, fmt.Errorf("%w: every member of %q has been deleted", ErrUnknownMember, d.Name)
+	}
+	if len(members) == 0 {
+		members = nil
+	}
+	d.Members = members
+
+	s.Trash = slices.Delete(slices.Clone(s.Trash), i, i+1)
+	s.Devices = append(s.Devices, d)
+	return d, s.commit(by, "restored from trash")
+}
+
+// PurgeDevice removes a device from the trash for good and returns it.
+func (s *Store) PurgeDevice(by Actor, key string) (Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.trashIndex(key)
+	if i < 0 {
+		return Device{}, ErrNotInTrash
+	}
+	d := s.Trash[i].Device
+	s.Trash = slices.Delete(slices.Clone(s.Trash), i, i+1)
+	return d, s.commit(by, "")
+}
+
+// EmptyTrash removes every trashed device for good and returns them.
+func (s *Store) EmptyTrash(by Actor) ([]Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	devices := make([]Device, 0, len(s.Trash))
+	for _, t := range s.Trash {
+		devices = append(devices, t.Device)
+	}
+	if len(devices) == 0 {
+		return devices, nil
+	}
+	s.Trash = nil
+	return devices, s.commit(by, "")
+}
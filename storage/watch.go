@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// watchDebounce lets editors and tools finish writing before reloading.
+const watchDebounce = 300 * time.Millisecond
+
+// pollInterval is how often the config file is checked when change
+// notifications from the OS are not available.
+const pollInterval = 2 * time.Second
+
+// Watch reloads the config whenever it is changed outside the program, e.g.
+// wol.json is edited by hand, until ctx is done. report is called with nil
+// after each successful reload, and with the error when an edit is rejected;
+// the current config is then kept. Writes made by the store itself are
+// recognised and do not cause a reload.
+func (s *Store) Watch(ctx context.Context, report func(error)) {
+	events := make(chan struct{}, 1)
+	changed := func() {
+		select {
+		case events <- struct{}{}:
+		default:
+		}
+	}
+
+	go s.backend.watch(ctx, changed)
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-events:
+			debounce = time.After(watchDebounce)
+		case <-debounce:
+			debounce = nil
+			reloaded, err := s.reload()
+			if err != nil {
+				report(err)
+			} else if reloaded {
+				report(nil)
+			}
+		}
+	}
+}
+
+// pollFile calls changed whenever the config file's size or modification
+// time changes.
+func (j *jsonFile) pollFile(ctx context.Context, changed func()) {
+	var lastMod time.Time
+	var lastSize int64
+	if fi, err := os.Stat(j.filename); err == nil {
+		lastMod, lastSize = fi.ModTime(), fi.Size()
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		fi, err := os.Stat(j.filename)
+		if err != nil {
+			continue
+		}
+		if !fi.ModTime().Equal(lastMod) || fi.Size() != lastSize {
+			lastMod, lastSize = fi.ModTime(), fi.Size()
+			changed()
+		}
+	}
+}
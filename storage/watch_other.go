@@ -0,0 +1,13 @@
+//go:build !linux
+
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// watchFile is only implemented on Linux; Watch falls back to polling.
+func (j *jsonFile) watchFile(ctx context.Context, changed func()) error {
+	return errors.New("file change notifications not supported")
+}
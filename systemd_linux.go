@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd socket
+// activation (SD_LISTEN_FDS_START).
+const listenFdsStart = 3
+
+// sdNotify sends a state update to systemd. It does nothing when the process
+// was not started by a Type=notify unit.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading '@' denotes an abstract socket.
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// startWatchdog pings the systemd watchdog at half the configured interval
+// until shutdown completes, as long as the server is alive (see alive) at
+// addr. A hung server misses the pings and is restarted.
+func startWatchdog(addr net.Addr) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	interval := time.Duration(usec) * time.Microsecond / 2
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-shutdownDone:
+				return
+			case <-ticker.C:
+				if alive(addr, interval) {
+					sdNotify("WATCHDOG=1")
+				}
+			}
+		}
+	}()
+}
+
+// alive reports whether the server answers an HTTP request on addr and the
+// config can be read, each within timeout.
+func alive(addr net.Addr, timeout time.Duration) bool {
+	if _, ok := addr.(*net.TCPAddr); ok {
+		client := &http.Client{Timeout: timeout}
+		resp, err := client.Get("http://" + displayAddr(addr) + "/")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+	}
+
+	// A request to / doesn't touch the store, so check its lock separately
+	read := make(chan struct{})
+	go func() {
+		store.GetSettings()
+		close(read)
+	}()
+	select {
+	case <-read:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// systemdListener returns the listener passed by systemd socket activation,
+// or nil if the process was not socket-activated.
+func systemdListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	// Don't pass the sockets on to any children we spawn.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if n > 1 {
+		return nil, errors.New("expected a single socket from systemd, got " + strconv.Itoa(n))
+	}
+	f := os.NewFile(listenFdsStart, "systemd-socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
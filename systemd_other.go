@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+func sdNotify(state string) error {
+	return nil
+}
+
+func startWatchdog(addr net.Addr) {}
+
+func systemdListener() (net.Listener, error) {
+	return nil, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"wol/logger"
+	"wol/storage"
+)
+
+// maxParallel bounds how many devices are pinged or woken at once when a
+// request targets many.
+const maxParallel = 16
+
+// deviceFilter reads ?tag= (repeatable, or comma separated), ?folder= and
+// ?q= into a filter.
+func deviceFilter(query url.Values) storage.Filter {
+	var tags []string
+	for _, v := range query["tag"] {
+		tags = append(tags, strings.Split(v, ",")...)
+	}
+	return storage.Filter{Tags: tags, Folder: query.Get("folder"), Query: query.Get("q")}
+}
+
+// filterDevices applies the filter of a GET /api/devices request, including
+// ?state=online|offline, which pings every device that passes the rest.
+func filterDevices(devices []storage.Device, query url.Values) ([]storage.Device, error) {
+	filter := deviceFilter(query)
+	var matched []storage.Device
+	for _, d := range devices {
+		if filter.Match(d) {
+			matched = append(matched, d)
+		}
+	}
+
+	state := query.Get("state")
+	if state == "" {
+		return matched, nil
+	}
+	if state != "online" && state != "offline" {
+		return nil, fmt.Errorf("invalid state %q (use online or offline)", state)
+	}
+	online := make([]bool, len(matched))
+	parallel(len(matched), func(i int) {
+		status, err := checkDevice(matched[i], store.Expand(matched[i]))
+		online[i] = err == nil && status.Online
+	})
+	var result []storage.Device
+	for i, d := range matched {
+		if online[i] == (state == "online") {
+			result = append(result, d)
+		}
+	}
+	return result, nil
+}
+
+// parallel calls fn for 0..n-1, at most maxParallel at a time, and waits.
+func parallel(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxParallel)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// wakeResult is the outcome of waking one of several devices.
+type wakeResult struct {
+	ID      string `json:"id"`
+	Device  string `json:"device"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// handleWakeTagged wakes every device matching ?tag= and ?folder=, as for
+// GET /api/devices. At least one of them must be left after cleaning, so a
+// bare or blank request can't wake everything, and it must match a device.
+func handleWakeTagged(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter := deviceFilter(r.URL.Query()).Clean()
+	filter.Query = ""
+	if len(filter.Tags) == 0 && filter.Folder == "" {
+		http.Error(w, "tag or folder is required", http.StatusBadRequest)
+		return
+	}
+
+	var targets []storage.Device
+	for _, d := range store.GetAll() {
+		if filter.Match(d) {
+			targets = append(targets, d)
+		}
+	}
+	if len(targets) == 0 {
+		http.Error(w, fmt.Sprintf("no device matches %s", describeFilter(filter)), http.StatusBadRequest)
+		return
+	}
+
+	ctx, done, ok := jobs.Start()
+	if !ok {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer done()
+
+	logger.Info("System", fmt.Sprintf("Waking %d devices (%s)", len(targets), describeFilter(filter)))
+	results := wakeAll(ctx, targets)
+	json.NewEncoder(w).Encode(results)
+}
+
+// wakeAll wakes devices in parallel and returns their outcomes in order.
+func wakeAll(ctx context.Context, devices []storage.Device) []wakeResult {
+	results := make([]wakeResult, len(devices))
+	parallel(len(devices), func(i int) {
+		d := devices[i]
+		results[i] = wakeResult{ID: d.ID, Device: d.Name}
+		if err := authorizeWake(d, storage.WakeProof{}, "wake by tag"); err != nil {
+			results[i].Error = err.Error()
+			return
+		}
+		cancelCooldown, err := checkCooldown(d, store.GetSettings(), "wake by tag")
+		if err != nil {
+			results[i].Error = err.Error()
+			return
+		}
+		msg, sent, err := wakeDevice(ctx, d, store.Expand(d))
+		if !sent {
+			cancelCooldown()
+		}
+		if err != nil {
+			results[i].Error = err.Error()
+		} else {
+			results[i].Message = msg
+		}
+	})
+	return results
+}
+
+// describeFilter renders a filter for log messages.
+func describeFilter(f storage.Filter) string {
+	var parts []string
+	for _, tag := range f.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			parts = append(parts, "tag "+tag)
+		}
+	}
+	if f.Folder != "" {
+		parts = append(parts, "folder "+f.Folder)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"wol/devicefile"
+	"wol/logger"
+	"wol/storage"
+)
+
+// maxImportSize bounds the body of an import request.
+const maxImportSize = 10 << 20
+
+// handleImport merges a device list given in the request body into the
+// config. ?format= selects the format (default json); with ?dry_run=1 only
+// the plan is returned.
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = devicefile.JSON
+	}
+	devices, err := devicefile.Read(format, http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dry_run") == "1" || r.URL.Query().Get("dry_run") == "true"
+	plan, err := store.Import(actorOf(r), devices, dryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logImport(plan, format)
+	plan.Added = exportDevices(plan.Added)
+	for i, c := range plan.Updated {
+		plan.Updated[i] = storage.DeviceChange{Old: c.Old.WithoutSecrets(), New: c.New.WithoutSecrets()}
+	}
+	json.NewEncoder(w).Encode(plan)
+}
+
+// handleExport returns all devices as a download in ?format= (default json).
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = devicefile.JSON
+	}
+	var buf bytes.Buffer
+	if err := devicefile.Write(format, &buf, exportDevices(store.GetAll())); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", devicefile.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"wol-devices%s\"", devicefile.Extension(format)))
+	buf.WriteTo(w)
+}
+
+// exportDevices returns devices as they are exported or shown after an
+// import, without secrets.
+func exportDevices(devices []storage.Device) []storage.Device {
+	out := make([]storage.Device, len(devices))
+	for i, d := range devices {
+		out[i] = d.WithoutSecrets()
+	}
+	return out
+}
+
+// logImport records the devices an import added or changed. Dry runs aren't
+// logged.
+func logImport(plan storage.ImportPlan, format string) {
+	if plan.DryRun {
+		return
+	}
+	for _, d := range plan.Added {
+		logger.DeviceInfo(d.ID, d.Name, "Device added (import)")
+	}
+	for _, c := range plan.Updated {
+		logger.DeviceInfo(c.New.ID, c.New.Name, "Device updated (import)")
+	}
+	logger.Info("System", fmt.Sprintf("Imported %s: %d added, %d updated, %d unchanged, %d conflicts",
+		format, len(plan.Added), len(plan.Updated), plan.Unchanged, len(plan.Conflicts)))
+}
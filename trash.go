@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"wol/logger"
+	"wol/storage"
+)
+
+// trashExpiryCheck is how often devices past trash_retention_days are
+// dropped from the trash while the server runs.
+const trashExpiryCheck = time.Hour
+
+// startTrashExpiry drops expired devices from the trash every
+// trashExpiryCheck until shutdown.
+func startTrashExpiry() {
+	jobs.Go(func(context.Context) {
+		ticker := time.NewTicker(trashExpiryCheck)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-background.Done():
+				return
+			case <-ticker.C:
+			}
+			devices, err := store.ExpireTrash()
+			for _, d := range devices {
+				logger.DeviceInfo(d.ID, d.Name, "Device purged from trash (expired)")
+			}
+			if err != nil {
+				logger.Error("System", fmt.Sprintf("Saving the expired trash failed: %v", err))
+			}
+		}
+	})
+}
+
+// trashErrorStatus is the HTTP status for an error restoring or purging a
+// trashed device.
+func trashErrorStatus(err error) int {
+	if errors.Is(err, storage.ErrNotInTrash) {
+		return http.StatusNotFound
+	}
+	return http.StatusConflict
+}
+
+// handleTrash lists the trashed devices, or empties the trash on DELETE.
+func handleTrash(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		trash := store.GetTrash()
+		for i := range trash {
+			trash[i].Device = trash[i].Device.WithoutSecrets()
+		}
+		json.NewEncoder(w).Encode(trash)
+	case http.MethodDelete:
+		devices, err := store.EmptyTrash(actorOf(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, d := range devices {
+			logger.DeviceInfo(d.ID, d.Name, "Device purged from trash")
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTrashAction serves POST /api/trash/{id}/restore and DELETE
+// /api/trash/{id}, which purges the device for good.
+func handleTrashAction(w http.ResponseWriter, r *http.Request) {
+	key := deviceKey(r, "/api/trash/")
+	if restoreKey, ok := strings.CutSuffix(key, "/restore"); ok && restoreKey != "" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		d, err := store.RestoreDevice(actorOf(r), restoreKey)
+		if err != nil {
+			http.Error(w, err.Error(), trashErrorStatus(err))
+			return
+		}
+		logger.DeviceInfo(d.ID, d.Name, "Device restored from trash")
+		json.NewEncoder(w).Encode(viewDevice(d))
+		return
+	}
+
+	if key == "" {
+		http.Error(w, "Device ID required", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d, err := store.PurgeDevice(actorOf(r), key)
+	if err != nil {
+		http.Error(w, err.Error(), trashErrorStatus(err))
+		return
+	}
+	logger.DeviceInfo(d.ID, d.Name, "Device purged from trash")
+	w.WriteHeader(http.StatusOK)
+}
+
+// purgeRequested reports whether a DELETE of a device asks to skip the trash.
+func purgeRequested(r *http.Request) bool {
+	purge := r.URL.Query().Get("purge")
+	return purge == "1" || purge == "true"
+}
+
+// logDelete logs a device moved to the trash, or purged right away.
+func logDelete(d storage.Device, purged bool) {
+	if purged {
+		logger.DeviceInfo(d.ID, d.Name, "Device deleted permanently")
+	} else {
+		logger.DeviceInfo(d.ID, d.Name, "Device moved to trash")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"wol/client"
+	"wol/logger"
+	"wol/storage"
+	"wol/wol"
+)
+
+var (
+	// errAdHocDisabled is returned for ad-hoc wakes while disable_adhoc_wake
+	// is set.
+	errAdHocDisabled = errors.New("waking MACs that aren't saved devices is disabled")
+	// errSavedDevice is returned for an ad-hoc wake of a MAC that belongs to
+	// a protected device or one in maintenance.
+	errSavedDevice = errors.New("MAC belongs to a saved device")
+)
+
+// adHocLabel is the device name ad-hoc wakes of mac are logged under.
+func adHocLabel(mac string) string {
+	return "MAC " + mac
+}
+
+// parseWakeMAC checks an ad-hoc wake request and returns the MAC in
+// canonical form and where to send the packets.
+func parseWakeMAC(req client.WakeMACRequest) (string, wol.Options, error) {
+	hw, err := net.ParseMAC(req.MAC)
+	if err != nil || len(hw) != 6 {
+		return "", wol.Options{}, fmt.Errorf("invalid MAC address %q", req.MAC)
+	}
+	password, err := wol.ParseSecureOn(req.SecureOn)
+	if err != nil {
+		return "", wol.Options{}, err
+	}
+	port := req.Port
+	if port == 0 {
+		port = 9
+	}
+	if port < 1 || port > 65535 {
+		return "", wol.Options{}, errors.New("invalid port number")
+	}
+	if req.BroadcastIP != "" && net.ParseIP(req.BroadcastIP) == nil {
+		return "", wol.Options{}, fmt.Errorf("invalid broadcast IP %q", req.BroadcastIP)
+	}
+	return hw.String(), wol.Options{BroadcastIP: req.BroadcastIP, Port: port, Password: password}, nil
+}
+
+// wakeMAC sends magic packets to a machine that doesn't need to be a saved
+// device, and logs it under adHocLabel. The protection and maintenance of a
+// saved device with the same MAC in s still apply. from names who asked for
+// the log.
+func wakeMAC(ctx context.Context, s *storage.Store, mac string, opts wol.Options, from string) (string, error) {
+	st := s.GetSettings()
+	if st.DisableAdHocWake {
+		return "", errAdHocDisabled
+	}
+	if d, ok := s.FindByMAC(mac); ok && (d.Protection != nil || d.InMaintenance()) {
+		return "", fmt.Errorf("%w %q, which is protected or in maintenance; wake it instead", errSavedDevice, d.Name)
+	}
+
+	label := adHocLabel(mac)
+	cancelCooldown, err := checkCooldown(storage.Device{ID: label, Name: label}, st, from)
+	if err != nil {
+		return "", err
+	}
+
+	targetDesc := opts.BroadcastIP
+	if targetDesc == "" {
+		targetDesc = "all interfaces"
+	}
+	msg := fmt.Sprintf("Sending WOL packets to %s:%d (ad-hoc, %s)...", targetDesc, opts.Port, from)
+	if opts.Password != nil {
+		msg = fmt.Sprintf("Sending WOL packets with SecureOn password to %s:%d (ad-hoc, %s)...", targetDesc, opts.Port, from)
+	}
+	logger.Info(label, msg)
+
+	if err := wol.WakeWith(ctx, mac, opts); err != nil {
+		cancelCooldown()
+		errMsg := fmt.Sprintf("Failed to send WOL packet: %v", err)
+		logger.Error(label, errMsg)
+		return "", errors.New(errMsg)
+	}
+
+	successMsg := fmt.Sprintf("Magic packets sent to %s:%d", targetDesc, opts.Port)
+	logger.Info(label, successMsg)
+	return successMsg, nil
+}
+
+// handleWakeMAC serves POST /api/wake-mac, which takes a
+// client.WakeMACRequest.
+func handleWakeMAC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req client.WakeMACRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mac, opts, err := parseWakeMAC(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, done, ok := jobs.Start()
+	if !ok {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer done()
+
+	msg, err := wakeMAC(ctx, store, mac, opts, describeActor(actorOf(r)))
+	var retry *retryError
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(msg))
+	case errors.Is(err, errAdHocDisabled):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, errSavedDevice):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.As(err, &retry):
+		setRetryAfter(w, retry.retry)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -1,10 +1,12 @@
 package wol
 
 import (
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -43,40 +45,104 @@ func NewMagicPacket(macAddr string) (*MagicPacket, error) {
 // Send sends the Magic Packet to the specified broadcast address and port.
 // broadcastAddr should be in the form "ip:port", e.g., "255.255.255.255:9" or "192.168.1.255:9".
 func (mp *MagicPacket) Send(broadcastAddr string) error {
-	conn, err := net.Dial("udp", broadcastAddr)
+	return send(nil, broadcastAddr, mp[:])
+}
+
+// ParseSecureOn parses a SecureOn password: 6 bytes written like a MAC
+// address, e.g. "01:02:03:04:05:06", or 4 written like an IPv4 address. An
+// empty password is nil.
+func ParseSecureOn(password string) ([]byte, error) {
+	if password == "" {
+		return nil, nil
+	}
+	if hw, err := net.ParseMAC(password); err == nil && len(hw) == 6 {
+		return hw, nil
+	}
+	if ip := net.ParseIP(password).To4(); ip != nil && !strings.Contains(password, ":") {
+		return ip, nil
+	}
+	return nil, errors.New("invalid SecureOn password, use 6 bytes like a MAC address or 4 like an IPv4 address")
+}
+
+// send sends data from the local address laddr, or any if it is nil.
+func send(laddr *net.UDPAddr, addr string, data []byte) error {
+	d := net.Dialer{}
+	if laddr != nil {
+		d.LocalAddr = laddr
+	}
+	conn, err := d.Dial("udp", addr)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
-	_, err = conn.Write(mp[:])
+	_, err = conn.Write(data)
 	return err
 }
 
+// Options select where WakeWith sends magic packets.
+type Options struct {
+	// BroadcastIP is the address to send to: a broadcast address, or the IP
+	// or host name of a relay that forwards the packet. If empty, packets go
+	// to the broadcast address of every IPv4 interface, or only Interface's.
+	BroadcastIP string
+	Port        int
+	// Interface sends the packets from this network interface's IPv4
+	// address, e.g. "eth1".
+	Interface string
+	// Password is a SecureOn password appended to the packet, 4 or 6 bytes,
+	// see ParseSecureOn. Cards with SecureOn enabled ignore packets without
+	// it.
+	Password []byte
+}
+
 // Wake sends a magic packet to the specified MAC address.
 // If broadcastIP is empty, it broadcasts to all available IPv4 interfaces.
 // It sends the packet multiple times with a delay between each send.
 func Wake(macAddr, broadcastIP string, port int) error {
+	return WakeContext(context.Background(), macAddr, broadcastIP, port)
+}
+
+// WakeContext is like Wake but stops sending further bursts once ctx is done.
+func WakeContext(ctx context.Context, macAddr, broadcastIP string, port int) error {
+	return WakeWith(ctx, macAddr, Options{BroadcastIP: broadcastIP, Port: port})
+}
+
+// WakeWith is like WakeContext with the destination given by opts.
+func WakeWith(ctx context.Context, macAddr string, opts Options) error {
 	mp, err := NewMagicPacket(macAddr)
 	if err != nil {
 		return err
 	}
+	if n := len(opts.Password); n != 0 && n != 4 && n != 6 {
+		return errors.New("SecureOn password must be 4 or 6 bytes")
+	}
+	data := append(mp[:], opts.Password...)
+
+	var laddr *net.UDPAddr
+	if opts.Interface != "" {
+		ip, err := interfaceIPv4(opts.Interface)
+		if err != nil {
+			return err
+		}
+		laddr = &net.UDPAddr{IP: ip}
+	}
 
 	var targets []string
-	if broadcastIP != "" {
-		targets = []string{fmt.Sprintf("%s:%d", broadcastIP, port)}
+	if opts.BroadcastIP != "" {
+		targets = []string{net.JoinHostPort(opts.BroadcastIP, strconv.Itoa(opts.Port))}
 	} else {
 		// Discover all broadcast addresses
-		addrs, err := getBroadcastAddresses()
+		addrs, err := getBroadcastAddresses(opts.Interface)
 		if err != nil {
 			return err
 		}
 		if len(addrs) == 0 {
 			// Fallback to global broadcast if no interfaces found (unlikely)
-			targets = []string{fmt.Sprintf("255.255.255.255:%d", port)}
+			targets = []string{fmt.Sprintf("255.255.255.255:%d", opts.Port)}
 		} else {
 			for _, addr := range addrs {
-				targets = append(targets, fmt.Sprintf("%s:%d", addr, port))
+				targets = append(targets, fmt.Sprintf("%s:%d", addr, opts.Port))
 			}
 		}
 	}
@@ -85,14 +151,38 @@ func Wake(macAddr, broadcastIP string, port int) error {
 	for i := 0; i < 5; i++ {
 		for _, target := range targets {
 			// We ignore errors for individual targets to ensure we try all
-			_ = mp.Send(target)
+			_ = send(laddr, target, data)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
 		}
-		time.Sleep(100 * time.Millisecond)
 	}
 	return nil
 }
 
-func getBroadcastAddresses() ([]string, error) {
+// interfaceIPv4 returns the first IPv4 address of the named interface.
+func interfaceIPv4(name string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.To4(), nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no IPv4 address", name)
+}
+
+// getBroadcastAddresses returns the broadcast addresses of every IPv4
+// interface that is up, or only of the one named only if it isn't empty.
+func getBroadcastAddresses(only string) ([]string, error) {
 	var list []string
 	ifaces, err := net.Interfaces()
 	if err != nil {
@@ -102,6 +192,9 @@ func getBroadcastAddresses() ([]string, error) {
 		if i.Flags&net.FlagUp == 0 || i.Flags&net.FlagLoopback != 0 {
 			continue
 		}
+		if only != "" && i.Name != only {
+			continue
+		}
 		addrs, err := i.Addrs()
 		if err != nil {
 			continue